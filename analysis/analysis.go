@@ -0,0 +1,265 @@
+// Package analysis measures edges, pulses and clocks of single bit signals read from a waveform
+//
+// Times are kept in time units of the waveform, measurements are converted to seconds and hertz
+// with the timescale of the waveform
+package analysis
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/elamre/vcd"
+)
+
+// Values of a single bit variable with the timescale of its waveform
+type Signal struct {
+	Name   string
+	Values []vcd.ReadValue
+	Unit   float64 // Seconds per time unit
+}
+
+// Creates a signal from values as returned by ReadAll, timescale is such as 1ns
+func NewSignal(name string, values []vcd.ReadValue, timeScale string) (Signal, error) {
+	unit, err := vcd.TimescaleSeconds(timeScale)
+	if err != nil {
+		return Signal{}, err
+	}
+	return Signal{Name: name, Values: values, Unit: unit}, nil
+}
+
+// Reads the signals with the given full names, such as example.logic.cs, from a waveform
+// The header is parsed first when that has not happened yet
+func Load(reader vcd.WaveReader, names ...string) ([]Signal, error) {
+	if len(reader.GetIdentifiers()) == 0 {
+		reader.ParseHeader()
+	}
+	values := reader.ReadAll()
+	var signals []Signal
+	for _, name := range names {
+		v, ok := values[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown signal %s", name)
+		}
+		signal, err := NewSignal(name, v, reader.Metadata().Timescale)
+		if err != nil {
+			return nil, err
+		}
+		signals = append(signals, signal)
+	}
+	return signals, nil
+}
+
+// Returns the level of a value, the last bit of vectors, false for x and z
+func level(value interface{}) (bool, bool) {
+	text := fmt.Sprint(value)
+	if text == "" {
+		return false, false
+	}
+	switch text[len(text)-1] {
+	case '0':
+		return false, true
+	case '1':
+		return true, true
+	}
+	return false, false
+}
+
+// Returns a duration in time units in seconds
+func (signal Signal) Seconds(units int64) float64 {
+	return float64(units) * signal.Unit
+}
+
+// Which edges an EdgeIterator returns
+type EdgeKind int
+
+const (
+	AnyEdge EdgeKind = iota
+	RisingEdge
+	FallingEdge
+)
+
+// Change of a signal between known levels
+type Edge struct {
+	Time   int64
+	Rising bool
+}
+
+// Iterates over the edges of a signal in time order, see Signal.Edges
+// Changes to x or z are skipped, the next known level decides the edge
+type EdgeIterator struct {
+	signal Signal
+	kind   EdgeKind
+	index  int
+	level  bool
+	known  bool
+}
+
+// Returns an iterator over the edges of the given kind
+func (signal Signal) Edges(kind EdgeKind) *EdgeIterator {
+	return &EdgeIterator{signal: signal, kind: kind}
+}
+
+// Returns the next edge, or false when there are no more edges
+func (iterator *EdgeIterator) Next() (Edge, bool) {
+	for iterator.index < len(iterator.signal.Values) {
+		value := iterator.signal.Values[iterator.index]
+		iterator.index++
+		current, known := level(value.Value)
+		if !known {
+			continue
+		}
+		changed := iterator.known && current != iterator.level
+		iterator.level, iterator.known = current, true
+		if changed && (iterator.kind == AnyEdge || (iterator.kind == RisingEdge) == current) {
+			return Edge{Time: value.Time, Rising: current}, true
+		}
+	}
+	return Edge{}, false
+}
+
+// Returns all edges of the given kind
+func (signal Signal) AllEdges(kind EdgeKind) []Edge {
+	var edges []Edge
+	iterator := signal.Edges(kind)
+	for edge, ok := iterator.Next(); ok; edge, ok = iterator.Next() {
+		edges = append(edges, edge)
+	}
+	return edges
+}
+
+// Time between two edges of a signal at the same level
+type Pulse struct {
+	Start int64
+	End   int64
+	High  bool
+}
+
+// Returns the complete pulses, the levels before the first and after the last edge are left out
+func (signal Signal) Pulses() []Pulse {
+	edges := signal.AllEdges(AnyEdge)
+	var pulses []Pulse
+	for i := 1; i < len(edges); i++ {
+		pulses = append(pulses, Pulse{Start: edges[i-1].Time, End: edges[i].Time, High: edges[i-1].Rising})
+	}
+	return pulses
+}
+
+// Shortest and longest high and low times of a signal in seconds
+type PulseStats struct {
+	HighPulses int
+	LowPulses  int
+	MinHigh    float64
+	MaxHigh    float64
+	MinLow     float64
+	MaxLow     float64
+}
+
+// Returns the shortest and longest high and low times
+func (signal Signal) PulseStats() PulseStats {
+	stats := PulseStats{}
+	for _, pulse := range signal.Pulses() {
+		width := signal.Seconds(pulse.End - pulse.Start)
+		if pulse.High {
+			if stats.HighPulses == 0 || width < stats.MinHigh {
+				stats.MinHigh = width
+			}
+			stats.MaxHigh = math.Max(stats.MaxHigh, width)
+			stats.HighPulses++
+		} else {
+			if stats.LowPulses == 0 || width < stats.MinLow {
+				stats.MinLow = width
+			}
+			stats.MaxLow = math.Max(stats.MaxLow, width)
+			stats.LowPulses++
+		}
+	}
+	return stats
+}
+
+// Counts of values in bins of equal width, the first bin starts at Start
+type Histogram struct {
+	Start    float64
+	BinWidth float64
+	Counts   []int
+}
+
+// Returns a histogram of values with the given number of bins from the smallest to the largest value
+func NewHistogram(values []float64, bins int) Histogram {
+	if len(values) == 0 || bins < 1 {
+		return Histogram{}
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	low, high := sorted[0], sorted[len(sorted)-1]
+	histogram := Histogram{Start: low, BinWidth: (high - low) / float64(bins), Counts: make([]int, bins)}
+	for _, value := range sorted {
+		bin := bins - 1
+		if histogram.BinWidth > 0 {
+			bin = min(int((value-low)/histogram.BinWidth), bins-1)
+		}
+		histogram.Counts[bin]++
+	}
+	return histogram
+}
+
+// Returns a histogram of the widths of the high or low pulses in seconds
+func (signal Signal) PulseHistogram(high bool, bins int) Histogram {
+	var widths []float64
+	for _, pulse := range signal.Pulses() {
+		if pulse.High == high {
+			widths = append(widths, signal.Seconds(pulse.End-pulse.Start))
+		}
+	}
+	return NewHistogram(widths, bins)
+}
+
+// Clock measured from the periods between rising edges, times in seconds and frequencies in hertz
+type Clock struct {
+	Periods    int
+	Period     float64 // Mean period
+	MinPeriod  float64
+	MaxPeriod  float64
+	Frequency  float64 // Frequency of the mean period
+	Jitter     float64 // Standard deviation of the periods
+	PeakToPeak float64 // Difference between the longest and the shortest period
+	DutyCycle  float64 // Mean high time divided by the mean period
+}
+
+// Measures the signal as a clock
+// Returns an error when there are fewer than two rising edges
+func (signal Signal) Clock() (Clock, error) {
+	rising := signal.AllEdges(RisingEdge)
+	if len(rising) < 2 {
+		return Clock{}, errors.New("at least two rising edges are needed to measure a clock")
+	}
+	clock := Clock{Periods: len(rising) - 1}
+	var periods []float64
+	for i := 1; i < len(rising); i++ {
+		period := signal.Seconds(rising[i].Time - rising[i-1].Time)
+		periods = append(periods, period)
+		clock.Period += period
+	}
+	clock.Period /= float64(len(periods))
+	clock.Frequency = 1 / clock.Period
+	sort.Float64s(periods)
+	clock.MinPeriod, clock.MaxPeriod = periods[0], periods[len(periods)-1]
+	clock.PeakToPeak = clock.MaxPeriod - clock.MinPeriod
+	for _, period := range periods {
+		clock.Jitter += (period - clock.Period) * (period - clock.Period)
+	}
+	clock.Jitter = math.Sqrt(clock.Jitter / float64(len(periods)))
+
+	high, count := 0.0, 0
+	for _, pulse := range signal.Pulses() {
+		if pulse.High {
+			high += signal.Seconds(pulse.End - pulse.Start)
+			count++
+		}
+	}
+	if count > 0 {
+		clock.DutyCycle = high / float64(count) / clock.Period
+	}
+	return clock, nil
+}
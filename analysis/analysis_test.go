@@ -0,0 +1,185 @@
+package analysis
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/elamre/vcd"
+	"github.com/elamre/vcd/internal/vcdtest"
+)
+
+func near(a float64, b float64) bool {
+	return math.Abs(a-b) <= 1e-6*math.Max(math.Abs(a), math.Abs(b))
+}
+
+// Writes a clock of module top with the given high and low times, starting low at 0
+func writeClock(t *testing.T, timeScale string, high []uint64, low []uint64) vcd.WaveReader {
+	changes := []vcdtest.Change{{Time: 0, Signal: "clk", Value: "0"}}
+	time := uint64(0)
+	for i := range high {
+		time += low[i]
+		changes = append(changes, vcdtest.Change{Time: time, Signal: "clk", Value: "1"})
+		time += high[i]
+		changes = append(changes, vcdtest.Change{Time: time, Signal: "clk", Value: "0"})
+	}
+	changes = append(changes, vcdtest.Change{Time: time + 5, Signal: "clk", Value: "x"})
+	return vcdtest.Write(t, timeScale, []string{"clk"}, changes)
+}
+
+func TestEdges(t *testing.T) {
+	signal, e := NewSignal("clk", []vcd.ReadValue{{Time: 0, Value: "0"}, {Time: 10, Value: "1"}, {Time: 15, Value: "x"},
+		{Time: 20, Value: "1"}, {Time: 30, Value: "0"}, {Time: 40, Value: "01"}}, "1ns")
+	vcdtest.Check(t, e)
+	if _, e = NewSignal("clk", nil, "1 parsec"); e == nil {
+		t.Fatal("expected an error for an invalid timescale")
+	}
+	edges := signal.AllEdges(AnyEdge)
+	if len(edges) != 3 || edges[0] != (Edge{10, true}) || edges[1] != (Edge{30, false}) || edges[2] != (Edge{40, true}) {
+		t.Fatalf("unexpected edges %v", edges)
+	}
+	if falling := signal.AllEdges(FallingEdge); len(falling) != 1 || falling[0].Time != 30 {
+		t.Fatalf("unexpected falling edges %v", falling)
+	}
+	iterator := signal.Edges(RisingEdge)
+	if edge, ok := iterator.Next(); !ok || edge.Time != 10 {
+		t.Fatalf("unexpected first rising edge %v", edge)
+	}
+	if edge, ok := iterator.Next(); !ok || edge.Time != 40 {
+		t.Fatalf("unexpected second rising edge %v", edge)
+	}
+	if _, ok := iterator.Next(); ok {
+		t.Fatal("expected no more edges")
+	}
+}
+
+func TestClock(t *testing.T) {
+	reader := writeClock(t, "10ps", []uint64{40, 50, 40, 50}, []uint64{60, 50, 60, 50})
+	signals, e := Load(reader, "top.clk")
+	vcdtest.Check(t, e)
+	signal := signals[0]
+
+	stats := signal.PulseStats()
+	if stats.HighPulses != 4 || stats.LowPulses != 3 || !near(stats.MinHigh, 400e-12) || !near(stats.MaxHigh, 500e-12) ||
+		!near(stats.MinLow, 500e-12) || !near(stats.MaxLow, 600e-12) {
+		t.Fatalf("unexpected pulse stats %+v", stats)
+	}
+
+	clock, e := signal.Clock()
+	vcdtest.Check(t, e)
+	// Periods of 900ps, 1100ps and 900ps
+	if clock.Periods != 3 || !near(clock.Period, 2900e-12/3) || !near(clock.Frequency, 3/2900e-12) || !near(clock.MinPeriod, 900e-12) ||
+		!near(clock.MaxPeriod, 1100e-12) || !near(clock.PeakToPeak, 200e-12) || !near(clock.Jitter, math.Sqrt(8)/3*100e-12) ||
+		!near(clock.DutyCycle, 450e-12/(2900e-12/3)) {
+		t.Fatalf("unexpected clock %+v", clock)
+	}
+
+	histogram := signal.PulseHistogram(true, 2)
+	if !near(histogram.Start, 400e-12) || !near(histogram.BinWidth, 50e-12) || len(histogram.Counts) != 2 ||
+		histogram.Counts[0] != 2 || histogram.Counts[1] != 2 {
+		t.Fatalf("unexpected histogram %+v", histogram)
+	}
+
+	signals, e = Load(writeClock(t, "1ns", nil, nil), "top.clk")
+	vcdtest.Check(t, e)
+	if _, e = signals[0].Clock(); e == nil {
+		t.Fatal("expected an error for a signal without edges")
+	}
+	if _, e = Load(writeClock(t, "1ns", nil, nil), "top.missing"); e == nil {
+		t.Fatal("expected an error for an unknown signal")
+	}
+}
+
+func TestTiming(t *testing.T) {
+	values := func(times ...int64) []vcd.ReadValue {
+		var values []vcd.ReadValue
+		for i, time := range times {
+			values = append(values, vcd.ReadValue{Time: time, Value: string(rune('0' + i%2))})
+		}
+		return values
+	}
+	data := Signal{Name: "top.d", Values: values(0, 12, 13, 28, 40, 51), Unit: 1e-9}
+	glitches := data.Glitches(2)
+	if len(glitches) != 1 || glitches[0] != (Violation{Kind: Glitch, Signal: "top.d", Time: 12, Width: 1}) {
+		t.Fatalf("unexpected glitches %v", glitches)
+	}
+
+	// Rising edges at 10, 30 and 50
+	clock := Signal{Name: "top.clk", Values: values(0, 10, 20, 30, 40, 50, 60), Unit: 1e-9}
+	violations := TimingCheck{Clock: clock, Edge: RisingEdge, Setup: 3, Hold: 3}.Check(data)
+	expected := []Violation{
+		{Kind: HoldViolation, Signal: "top.d", Time: 12, Width: 2, Edge: 10},
+		{Kind: SetupViolation, Signal: "top.d", Time: 28, Width: 2, Edge: 30},
+		{Kind: HoldViolation, Signal: "top.d", Time: 51, Width: 1, Edge: 50},
+	}
+	if len(violations) != len(expected) {
+		t.Fatalf("unexpected violations %v", violations)
+	}
+	for i := range expected {
+		if violations[i] != expected[i] {
+			t.Fatalf("unexpected violation %v, expected %v", violations[i], expected[i])
+		}
+	}
+
+	report := NewTimingReport(1e-9, append(violations, glitches...)...)
+	var text strings.Builder
+	_, e := report.WriteTo(&text)
+	vcdtest.Check(t, e)
+	lines := strings.Split(text.String(), "\n")
+	if len(lines) != 5 || lines[0] != "12 (12ns): hold violation on top.d, 2 (2ns) from the clock edge at 10" ||
+		lines[1] != "12 (12ns): glitch on top.d, 1 (1ns) wide" {
+		t.Fatalf("unexpected report %q", text.String())
+	}
+	if FormatSeconds(1.5e-6) != "1.5us" || FormatSeconds(2) != "2s" || FormatSeconds(0) != "0s" {
+		t.Fatalf("unexpected formatting %s %s", FormatSeconds(1.5e-6), FormatSeconds(2))
+	}
+
+	var save strings.Builder
+	gtkw := vcd.NewGtkwWriter(&save)
+	vcdtest.Check(t, report.Mark(&gtkw, -2))
+	vcdtest.Check(t, gtkw.Close())
+	if !strings.Contains(save.String(), "*-2.000000 12 12 12 28 51 -1") || !strings.Contains(save.String(), "[markername] Bglitch top.d") {
+		t.Fatalf("unexpected save file %s", save.String())
+	}
+}
+
+func TestRewrittenValues(t *testing.T) {
+	// A long high pulse with the same value written again, as by $dumpvars, is not a glitch
+	data := Signal{Name: "top.d", Unit: 1e-9, Values: []vcd.ReadValue{{Time: 0, Value: "0"}, {Time: 10, Value: "1"},
+		{Time: 11, Value: "1"}, {Time: 12, Value: "1"}, {Time: 50, Value: "0"}, {Time: 50, Value: "0"}, {Time: 90, Value: "1"}}}
+	if glitches := data.Glitches(5); len(glitches) != 0 {
+		t.Fatalf("unexpected glitches %v", glitches)
+	}
+
+	// Rising edges at 10, 30, 50 and 70, a change at the edge violates the setup time
+	clock := Signal{Name: "top.clk", Values: []vcd.ReadValue{{Time: 0, Value: "0"}, {Time: 10, Value: "1"}, {Time: 20, Value: "0"},
+		{Time: 30, Value: "1"}, {Time: 40, Value: "0"}, {Time: 50, Value: "1"}, {Time: 60, Value: "0"}, {Time: 70, Value: "1"}}}
+	violations := TimingCheck{Clock: clock, Edge: RisingEdge, Setup: 2}.Check(data)
+	if len(violations) != 2 || violations[0] != (Violation{Kind: SetupViolation, Signal: "top.d", Time: 10, Edge: 10}) ||
+		violations[1] != (Violation{Kind: SetupViolation, Signal: "top.d", Time: 50, Edge: 50}) {
+		t.Fatalf("unexpected violations %v", violations)
+	}
+	violations = TimingCheck{Clock: clock, Edge: RisingEdge, Hold: 2}.Check(data)
+	if len(violations) != 2 || violations[0].Kind != HoldViolation || violations[1].Time != 50 {
+		t.Fatalf("unexpected violations %v", violations)
+	}
+}
+
+func TestLoadTimescale(t *testing.T) {
+	for _, header := range []string{"$timescale 1 ns $end\n", "$timescale\n\t1ns\n$end\n"} {
+		filename := filepath.Join(t.TempDir(), "icarus.vcd")
+		contents := header + "$scope module top $end\n$var wire 1 ! clk $end\n$upscope $end\n$enddefinitions $end\n" +
+			"#0\n0!\n#5\n1!\n#10\n0!\n#15\n1!\n"
+		vcdtest.Check(t, os.WriteFile(filename, []byte(contents), 0644))
+		reader, e := vcd.NewReader(filename)
+		vcdtest.Check(t, e)
+		signals, e := Load(&reader, "top.clk")
+		reader.Close()
+		vcdtest.Check(t, e)
+		if clock, e := signals[0].Clock(); e != nil || !near(clock.Frequency, 1e8) {
+			t.Fatalf("%q: unexpected clock %+v %v", header, clock, e)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package analysis
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/elamre/vcd"
+)
+
+// Kind of a timing violation
+type ViolationKind int
+
+const (
+	Glitch ViolationKind = iota
+	SetupViolation
+	HoldViolation
+)
+
+func (kind ViolationKind) String() string {
+	switch kind {
+	case Glitch:
+		return "glitch"
+	case SetupViolation:
+		return "setup"
+	case HoldViolation:
+		return "hold"
+	}
+	return fmt.Sprintf("ViolationKind(%d)", int(kind))
+}
+
+// Timing violation found by Glitches or TimingCheck, times in time units of the waveform
+type Violation struct {
+	Kind   ViolationKind
+	Signal string
+	Time   int64 // Start of the glitch, or the change of the data signal
+	Width  int64 // Width of the glitch, or the distance between the change and the clock edge
+	Edge   int64 // Clock edge of setup and hold violations
+}
+
+func (violation Violation) String() string {
+	if violation.Kind == Glitch {
+		return fmt.Sprintf("%d: glitch on %s, %d wide", violation.Time, violation.Signal, violation.Width)
+	}
+	return fmt.Sprintf("%d: %s violation on %s, %d from the clock edge at %d",
+		violation.Time, violation.Kind, violation.Signal, violation.Width, violation.Edge)
+}
+
+// Returns the values which differ from the one before, dropping rewrites of the same value such as by $dumpvars
+func (signal Signal) changes() []vcd.ReadValue {
+	var changes []vcd.ReadValue
+	for _, value := range signal.Values {
+		if len(changes) == 0 || value.Value != changes[len(changes)-1].Value {
+			changes = append(changes, value)
+		}
+	}
+	return changes
+}
+
+// Returns the values held for less than threshold time units, including changes to x and z
+// The first value, which is held since the start of the dump, is not checked
+func (signal Signal) Glitches(threshold int64) []Violation {
+	var violations []Violation
+	changes := signal.changes()
+	for i := 1; i+1 < len(changes); i++ {
+		width := changes[i+1].Time - changes[i].Time
+		if width < threshold {
+			violations = append(violations, Violation{Kind: Glitch, Signal: signal.Name, Time: changes[i].Time, Width: width})
+		}
+	}
+	return violations
+}
+
+// Setup and hold check of data signals against the edges of a clock
+// Data must not change within Setup time units before an edge, or before Hold time units have passed after it
+// A change at the edge itself violates the setup time when Setup is not 0, and the hold time otherwise
+type TimingCheck struct {
+	Clock Signal
+	Edge  EdgeKind
+	Setup int64
+	Hold  int64
+}
+
+// Returns the setup and hold violations of the data signals, in time order per signal
+func (check TimingCheck) Check(data ...Signal) []Violation {
+	var edges []int64
+	iterator := check.Clock.Edges(check.Edge)
+	for edge, ok := iterator.Next(); ok; edge, ok = iterator.Next() {
+		edges = append(edges, edge.Time)
+	}
+	var violations []Violation
+	for _, signal := range data {
+		changes := signal.changes()
+		for _, value := range changes[min(1, len(changes)):] {
+			// First edge at or after the change, and the one before it
+			next := sort.Search(len(edges), func(i int) bool { return edges[i] >= value.Time })
+			setup := next < len(edges) && edges[next]-value.Time < check.Setup
+			if setup {
+				violations = append(violations, Violation{Kind: SetupViolation, Signal: signal.Name, Time: value.Time,
+					Width: edges[next] - value.Time, Edge: edges[next]})
+			}
+			previous := next - 1
+			if next < len(edges) && edges[next] == value.Time {
+				if setup {
+					continue
+				}
+				previous = next
+			}
+			if previous >= 0 && value.Time-edges[previous] < check.Hold {
+				violations = append(violations, Violation{Kind: HoldViolation, Signal: signal.Name, Time: value.Time,
+					Width: value.Time - edges[previous], Edge: edges[previous]})
+			}
+		}
+	}
+	return violations
+}
+
+// Violations of a waveform with the duration of its time unit in seconds
+type TimingReport struct {
+	Violations []Violation
+	Unit       float64
+}
+
+// Creates a report sorted by time
+func NewTimingReport(unit float64, violations ...Violation) TimingReport {
+	sorted := append([]Violation(nil), violations...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Time < sorted[j].Time })
+	return TimingReport{Violations: sorted, Unit: unit}
+}
+
+// Formats seconds with an SI prefix, such as 1.5ns
+func FormatSeconds(seconds float64) string {
+	prefixes := []string{"f", "p", "n", "u", "m", ""}
+	if seconds == 0 {
+		return "0s"
+	}
+	exponent := min(max(int(math.Floor(math.Log10(math.Abs(seconds))/3)), -5), 0)
+	return fmt.Sprintf("%g%ss", seconds/math.Pow10(3*exponent), prefixes[exponent+5])
+}
+
+// Writes one line per violation with the times in time units and seconds
+func (report TimingReport) WriteTo(w io.Writer) (int64, error) {
+	var text strings.Builder
+	for _, violation := range report.Violations {
+		seconds := func(units int64) string { return FormatSeconds(float64(units) * report.Unit) }
+		switch violation.Kind {
+		case Glitch:
+			fmt.Fprintf(&text, "%d (%s): glitch on %s, %d (%s) wide\n", violation.Time, seconds(violation.Time),
+				violation.Signal, violation.Width, seconds(violation.Width))
+		default:
+			fmt.Fprintf(&text, "%d (%s): %s violation on %s, %d (%s) from the clock edge at %d\n", violation.Time,
+				seconds(violation.Time), violation.Kind, violation.Signal, violation.Width, seconds(violation.Width), violation.Edge)
+		}
+	}
+	n, err := io.WriteString(w, text.String())
+	return int64(n), err
+}
+
+// Returns named markers at the first violations, GTKWave supports up to 26 markers
+func (report TimingReport) Markers() []vcd.GtkwMarker {
+	var markers []vcd.GtkwMarker
+	for _, violation := range report.Violations[:min(len(report.Violations), 26)] {
+		markers = append(markers, vcd.GtkwMarker{Time: violation.Time, Name: fmt.Sprintf("%s %s", violation.Kind, violation.Signal)})
+	}
+	return markers
+}
+
+// Sets the markers of a save file to the first violations and the primary marker to the first one
+func (report TimingReport) Mark(gtkw *vcd.Gtkw, zoom float64) error {
+	primary := int64(-1)
+	if len(report.Violations) > 0 {
+		primary = report.Violations[0].Time
+	}
+	return gtkw.SetZoom(zoom, primary, report.Markers()...)
+}
@@ -0,0 +1,361 @@
+// Package assertion checks temporal properties of a waveform, sampled at the edges of a clock
+//
+// Properties are built from expressions over the sampled signals, such as Rose, Stable and Past,
+// sequences with delay ranges, and implications:
+//
+//	assertion.Implies(assertion.Rose("top.req"), assertion.Delay(1, 10, assertion.Rose("top.ack")))
+//
+// Signals are sampled just before the clock edge, values changing at the edge are seen at the next one
+package assertion
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Values of the signals sampled at the clock edges
+type trace struct {
+	times  []int64
+	values [][]string // Indexed by cycle and input
+	index  map[string]int
+}
+
+// Returns the sampled value of a signal, x before the first cycle
+func (trace *trace) sample(name string, cycle int) string {
+	if cycle < 0 {
+		return "x"
+	}
+	return trace.values[cycle][trace.index[name]]
+}
+
+// Returns the least significant bit of a value
+func lsb(value string) byte {
+	if value == "" {
+		return 'x'
+	}
+	return value[len(value)-1]
+}
+
+// Returns whether a value is true, a vector with a bit set or a real other than 0
+func truthy(value string) bool {
+	if strings.ContainsRune(value, '1') {
+		return true
+	}
+	number, err := strconv.ParseFloat(value, 64)
+	return err == nil && number != 0
+}
+
+// Sequence of cycles, matched from a start cycle
+type Sequence interface {
+	// Returns the cycles at which matches starting at cycle end, the last cycle looked at,
+	// and whether the end of the trace cut matching short
+	// With first set, matching stops at the first match found
+	match(trace *trace, cycle int, first bool) ([]int, int, bool)
+	// Returns the names of the sampled signals
+	signals() []string
+	String() string
+}
+
+// Boolean expression over the signals of a single cycle, which is also a sequence of one cycle
+type Expr struct {
+	holds func(trace *trace, cycle int) bool
+	names []string
+	text  string
+}
+
+func (expr Expr) match(trace *trace, cycle int, _ bool) ([]int, int, bool) {
+	if cycle >= len(trace.times) {
+		return nil, len(trace.times) - 1, true
+	}
+	if expr.holds(trace, cycle) {
+		return []int{cycle}, cycle, false
+	}
+	return nil, cycle, false
+}
+
+func (expr Expr) signals() []string {
+	return expr.names
+}
+
+func (expr Expr) String() string {
+	return expr.text
+}
+
+// Expression which always holds
+var True = Expr{holds: func(*trace, int) bool { return true }, text: "1"}
+
+// Holds when any bit of a signal is 1, or a real signal is not 0
+func Signal(name string) Expr {
+	return Expr{
+		holds: func(trace *trace, cycle int) bool { return truthy(trace.sample(name, cycle)) },
+		names: []string{name},
+		text:  name,
+	}
+}
+
+// Holds when a signal equals value, x and z bits never match
+func Equals(name string, value uint64) Expr {
+	return Expr{
+		holds: func(trace *trace, cycle int) bool {
+			sample := trace.sample(name, cycle)
+			if number, ok := new(big.Int).SetString(sample, 2); ok {
+				return number.IsUint64() && number.Uint64() == value
+			}
+			number, err := strconv.ParseFloat(sample, 64)
+			return err == nil && number == float64(value)
+		},
+		names: []string{name},
+		text:  fmt.Sprintf("%s == %d", name, value),
+	}
+}
+
+// Holds when the least significant bit of a signal changed to 1 since the previous cycle
+func Rose(name string) Expr {
+	return Expr{
+		holds: func(trace *trace, cycle int) bool {
+			return lsb(trace.sample(name, cycle)) == '1' && lsb(trace.sample(name, cycle-1)) != '1'
+		},
+		names: []string{name},
+		text:  fmt.Sprintf("$rose(%s)", name),
+	}
+}
+
+// Holds when the least significant bit of a signal changed to 0 since the previous cycle
+func Fell(name string) Expr {
+	return Expr{
+		holds: func(trace *trace, cycle int) bool {
+			return lsb(trace.sample(name, cycle)) == '0' && lsb(trace.sample(name, cycle-1)) != '0'
+		},
+		names: []string{name},
+		text:  fmt.Sprintf("$fell(%s)", name),
+	}
+}
+
+// Holds when a signal has the same value as in the previous cycle
+func Stable(name string) Expr {
+	return Expr{
+		holds: func(trace *trace, cycle int) bool { return trace.sample(name, cycle) == trace.sample(name, cycle-1) },
+		names: []string{name},
+		text:  fmt.Sprintf("$stable(%s)", name),
+	}
+}
+
+// Holds when a signal has a different value than in the previous cycle
+func Changed(name string) Expr {
+	return Stable(name).Not().withText(fmt.Sprintf("$changed(%s)", name))
+}
+
+// Holds when expr held the given number of cycles earlier, never before the first cycle
+func Past(expr Expr, cycles int) Expr {
+	return Expr{
+		holds: func(trace *trace, cycle int) bool { return cycle-cycles >= 0 && expr.holds(trace, cycle-cycles) },
+		names: expr.names,
+		text:  fmt.Sprintf("$past(%s, %d)", expr.text, cycles),
+	}
+}
+
+func (expr Expr) withText(text string) Expr {
+	expr.text = text
+	return expr
+}
+
+// Holds when expr does not hold
+func (expr Expr) Not() Expr {
+	return Expr{
+		holds: func(trace *trace, cycle int) bool { return !expr.holds(trace, cycle) },
+		names: expr.names,
+		text:  fmt.Sprintf("!(%s)", expr.text),
+	}
+}
+
+// Holds when expr and all others hold
+func (expr Expr) And(others ...Expr) Expr {
+	return combine(expr, others, "&&", func(a bool, b bool) bool { return a && b })
+}
+
+// Holds when expr or any of the others holds
+func (expr Expr) Or(others ...Expr) Expr {
+	return combine(expr, others, "||", func(a bool, b bool) bool { return a || b })
+}
+
+func combine(expr Expr, others []Expr, operator string, op func(bool, bool) bool) Expr {
+	exprs := append([]Expr{expr}, others...)
+	var names, texts []string
+	for _, e := range exprs {
+		names = append(names, e.names...)
+		texts = append(texts, e.text)
+	}
+	return Expr{
+		holds: func(trace *trace, cycle int) bool {
+			result := exprs[0].holds(trace, cycle)
+			for _, e := range exprs[1:] {
+				result = op(result, e.holds(trace, cycle))
+			}
+			return result
+		},
+		names: names,
+		text:  "(" + strings.Join(texts, " "+operator+" ") + ")",
+	}
+}
+
+// Sequence first ##[min:max] next
+type delaySequence struct {
+	first Sequence
+	min   int
+	max   int
+	next  Sequence
+}
+
+// Returns the sequence in which next starts between min and max cycles after the end of first
+// A max of -1 allows any delay up to the end of the trace
+func Then(first Sequence, min int, max int, next Sequence) Sequence {
+	return delaySequence{first, min, max, next}
+}
+
+// Returns the sequence in which seq starts between min and max cycles after the current cycle, see Then
+func Delay(min int, max int, seq Sequence) Sequence {
+	return delaySequence{True, min, max, seq}
+}
+
+func (seq delaySequence) match(trace *trace, cycle int, first bool) ([]int, int, bool) {
+	// Every end of the first sequence is needed, a later one may still lead to a match
+	firstEnds, last, incomplete := seq.first.match(trace, cycle, false)
+	found := make(map[int]bool)
+	var ends []int
+	for _, end := range firstEnds {
+		for delay := seq.min; seq.max < 0 || delay <= seq.max; delay++ {
+			if end+delay >= len(trace.times) {
+				incomplete = true
+				last = max(last, len(trace.times)-1)
+				break
+			}
+			nextEnds, nextLast, nextIncomplete := seq.next.match(trace, end+delay, first)
+			last, incomplete = max(last, nextLast), incomplete || nextIncomplete
+			for _, nextEnd := range nextEnds {
+				if !found[nextEnd] {
+					found[nextEnd] = true
+					ends = append(ends, nextEnd)
+				}
+			}
+			if first && len(ends) > 0 {
+				return ends, last, incomplete
+			}
+		}
+	}
+	sort.Ints(ends)
+	return ends, last, incomplete
+}
+
+func (seq delaySequence) signals() []string {
+	return append(append([]string(nil), seq.first.signals()...), seq.next.signals()...)
+}
+
+func (seq delaySequence) String() string {
+	delay := fmt.Sprintf("##[%d:%d]", seq.min, seq.max)
+	if seq.min == seq.max {
+		delay = fmt.Sprintf("##%d", seq.min)
+	} else if seq.max < 0 {
+		delay = fmt.Sprintf("##[%d:$]", seq.min)
+	}
+	if expr, ok := seq.first.(Expr); ok && expr.text == True.text {
+		return fmt.Sprintf("%s %s", delay, seq.next)
+	}
+	return fmt.Sprintf("%s %s %s", seq.first, delay, seq.next)
+}
+
+// Outcome of a property for one start cycle
+type verdict int
+
+const (
+	vacuous verdict = iota // The antecedent did not match
+	pass
+	fail
+	pending // The trace ended before the property was decided
+)
+
+// Temporal property checked from every cycle of a trace
+type Property interface {
+	// Returns the verdict for the attempt starting at cycle and the cycle it was decided at
+	check(trace *trace, cycle int) (verdict, int)
+	signals() []string
+	String() string
+}
+
+// Property antecedent |-> consequent
+type implication struct {
+	antecedent Sequence
+	consequent Sequence
+}
+
+// Returns the property that consequent matches from the end of every match of antecedent
+func Implies(antecedent Sequence, consequent Sequence) Property {
+	return implication{antecedent, consequent}
+}
+
+// Returns the property that seq matches from every cycle
+func Always(seq Sequence) Property {
+	return implication{True, seq}
+}
+
+func (property implication) check(trace *trace, cycle int) (verdict, int) {
+	ends, last, _ := property.antecedent.match(trace, cycle, false)
+	result := vacuous
+	for _, end := range ends {
+		// A single match of the consequent is enough
+		matches, consequentLast, incomplete := property.consequent.match(trace, end, true)
+		last = max(last, consequentLast)
+		switch {
+		case len(matches) > 0:
+			last = max(last, matches[0])
+			result = max(result, pass)
+		case incomplete:
+			result = pending
+		default:
+			return fail, last
+		}
+	}
+	return result, last
+}
+
+func (property implication) signals() []string {
+	return append(append([]string(nil), property.antecedent.signals()...), property.consequent.signals()...)
+}
+
+func (property implication) String() string {
+	if expr, ok := property.antecedent.(Expr); ok && expr.text == True.text {
+		return property.consequent.String()
+	}
+	return fmt.Sprintf("%s |-> %s", property.antecedent, property.consequent)
+}
+
+// Property that seq never matches
+type never struct {
+	seq Sequence
+}
+
+// Returns the property that seq does not match from any cycle
+func Never(seq Sequence) Property {
+	return never{seq}
+}
+
+func (property never) check(trace *trace, cycle int) (verdict, int) {
+	ends, last, incomplete := property.seq.match(trace, cycle, true)
+	switch {
+	case len(ends) > 0:
+		return fail, ends[0]
+	case incomplete:
+		return pending, last
+	}
+	return pass, last
+}
+
+func (property never) signals() []string {
+	return property.seq.signals()
+}
+
+func (property never) String() string {
+	return fmt.Sprintf("not %s", property.seq)
+}
@@ -0,0 +1,141 @@
+package assertion
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/elamre/vcd"
+	"github.com/elamre/vcd/internal/vcdtest"
+)
+
+// Writes a clock with rising edges at 5, 15, 25... for the given number of cycles, and signals of module top
+// Levels holds the value of each signal per cycle n, which changes at 10n+1
+func writeCycles(t *testing.T, cycles int, levels map[string]string) vcd.WaveReader {
+	signals := []string{"clk"}
+	for name := range levels {
+		signals = append(signals, name)
+	}
+	var changes []vcdtest.Change
+	for cycle := 0; cycle < cycles; cycle++ {
+		time := uint64(cycle * 10)
+		changes = append(changes, vcdtest.Change{Time: time, Signal: "clk", Value: "0"})
+		for _, name := range signals[1:] {
+			if cycle < len(levels[name]) {
+				changes = append(changes, vcdtest.Change{Time: time + min(time, 1), Signal: name, Value: levels[name][cycle : cycle+1]})
+			}
+		}
+		changes = append(changes, vcdtest.Change{Time: time + 5, Signal: "clk", Value: "1"})
+	}
+	return vcdtest.Write(t, "1ns", signals, changes)
+}
+
+func TestImplication(t *testing.T) {
+	// Cycle   0123456789012345
+	req := "0110000001000000"
+	ack := "0000100000000000"
+	reader := writeCycles(t, len(req), map[string]string{"req": req, "ack": ack})
+	results, e := Check(reader, "top.clk",
+		Assertion{"ack", Implies(Rose("top.req"), Delay(1, 3, Rose("top.ack")))},
+		Assertion{"stable", Implies(Signal("top.req").And(Past(Signal("top.req"), 1)), Stable("top.ack"))},
+		Assertion{"no ack without req", Never(Rose("top.ack").And(Past(Signal("top.req"), 1).Not(), Past(Signal("top.req"), 2).Not()))},
+	)
+	vcdtest.Check(t, e)
+
+	// Rising edges sample the values of cycle n at time 10n+5, req rises in cycles 1 and 9
+	result := results[0]
+	if result.Attempts != 2 || result.Passes != 1 || len(result.Failures) != 1 || result.Pending != 0 {
+		t.Fatalf("unexpected result %s", result)
+	}
+	failure := result.Failures[0]
+	if failure.Start != 95 || failure.End != 125 {
+		t.Fatalf("unexpected failure %+v", failure)
+	}
+	lines := strings.Split(failure.Excerpt, "\n")
+	if len(lines) != 4 || lines[0] != "time    75 85 95 105 115 125 135 145" ||
+		lines[1] != "top.req  0  0  1   0   0   0   0   0" || lines[2] != "top.ack  0  0  0   0   0   0   0   0" {
+		t.Fatalf("unexpected excerpt\n%s", failure.Excerpt)
+	}
+	if !strings.Contains(result.String(), "failed at 125, started at 95: $rose(top.req) |-> ##[1:3] $rose(top.ack)") {
+		t.Fatalf("unexpected text %s", result)
+	}
+
+	if stable := results[1]; stable.Attempts != 1 || stable.Passes != 1 {
+		t.Fatalf("unexpected result %s", stable)
+	}
+	if never := results[2]; len(never.Failures) != 0 || never.Passes != len(req) {
+		t.Fatalf("unexpected result %s", never)
+	}
+}
+
+func TestPending(t *testing.T) {
+	req := "00010"
+	reader := writeCycles(t, len(req), map[string]string{"req": req, "ack": "0"})
+	results, e := Check(reader, "top.clk",
+		Assertion{"ack", Implies(Rose("top.req"), Delay(1, 3, Signal("top.ack")))},
+		Assertion{"eventually", Implies(Rose("top.req"), Delay(0, -1, Signal("top.ack")))},
+		Assertion{"then", Always(Then(Fell("top.req"), 1, 1, Signal("top.ack").Not()))},
+	)
+	vcdtest.Check(t, e)
+	if results[0].Pending != 1 || len(results[0].Failures) != 0 || results[1].Pending != 1 {
+		t.Fatalf("unexpected results %s\n%s", results[0], results[1])
+	}
+	if then := results[2]; then.Passes != 1 || len(then.Failures) != 3 || then.Pending != 1 || then.Property != "$fell(top.req) ##1 !(top.ack)" {
+		t.Fatalf("unexpected result %s", then)
+	}
+	if _, e = Check(reader, "top.missing"); e == nil {
+		t.Fatal("expected an error for an unknown clock")
+	}
+}
+
+func TestLongTrace(t *testing.T) {
+	// Req rises every 10 cycles and ack 3 cycles later, an unbounded delay must stop at the first match
+	const cycles = 100000
+	trace := &trace{index: map[string]int{"top.req": 0, "top.ack": 1}}
+	level := func(high bool) string {
+		if high {
+			return "1"
+		}
+		return "0"
+	}
+	for cycle := 0; cycle < cycles; cycle++ {
+		trace.times = append(trace.times, int64(cycle*10))
+		trace.values = append(trace.values, []string{level(cycle%10 == 1), level(cycle%10 == 4)})
+	}
+	property := Implies(Rose("top.req"), Delay(1, -1, Rose("top.ack")))
+	passes := 0
+	for cycle := range trace.times {
+		if verdict, end := property.check(trace, cycle); verdict == pass {
+			passes++
+			if end != cycle+3 {
+				t.Fatalf("unexpected end %d of the attempt at %d", end, cycle)
+			}
+		} else if verdict != vacuous {
+			t.Fatalf("unexpected verdict %d at %d", verdict, cycle)
+		}
+	}
+	if passes != cycles/10 {
+		t.Fatalf("unexpected passes %d", passes)
+	}
+}
+
+func TestWideBus(t *testing.T) {
+	wide := strings.Repeat("0", 64) + "1010"
+	trace := &trace{times: []int64{0, 10}, values: [][]string{{wide}, {"1" + wide}}, index: map[string]int{"top.bus": 0}}
+	equals := Equals("top.bus", 10)
+	if !equals.holds(trace, 0) || equals.holds(trace, 1) {
+		t.Fatal("unexpected comparison of a bus wider than 64 bits")
+	}
+
+	checker := NewChecker("top.clk", Assertion{"clock", Always(Signal("top.clk").Not())})
+	if len(checker.Inputs()) != 1 {
+		t.Fatalf("unexpected inputs %v", checker.Inputs())
+	}
+	checker.Change(0, 0, "0")
+	checker.Change(5, 0, "1")
+	checker.Change(10, 0, "0")
+	checker.Change(15, 0, "1")
+	checker.Finish(15)
+	if result := checker.Results[0]; result.Passes != 2 {
+		t.Fatalf("unexpected result %s", result)
+	}
+}
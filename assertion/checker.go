@@ -0,0 +1,181 @@
+package assertion
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/elamre/vcd"
+	"github.com/elamre/vcd/decode"
+)
+
+// Named property
+type Assertion struct {
+	Name     string
+	Property Property
+}
+
+// Failed attempt of an assertion
+type Failure struct {
+	Start   int64  // Time of the clock edge the attempt started at
+	End     int64  // Time of the clock edge the failure was detected at
+	Excerpt string // Sampled values of the signals of the property around the failure
+}
+
+// Outcome of an assertion over a whole trace
+type Result struct {
+	Assertion string
+	Property  string
+	Attempts  int // Cycles the antecedent matched at
+	Passes    int
+	Pending   int // Attempts not decided when the trace ended
+	Failures  []Failure
+}
+
+func (result Result) String() string {
+	text := fmt.Sprintf("%s: %d attempts, %d passed, %d failed, %d pending",
+		result.Assertion, result.Attempts, result.Passes, len(result.Failures), result.Pending)
+	for _, failure := range result.Failures {
+		text += fmt.Sprintf("\nfailed at %d, started at %d: %s\n%s", failure.End, failure.Start, result.Property, failure.Excerpt)
+	}
+	return text
+}
+
+// Checks assertions against signals sampled at the edges of a clock
+// Checker is a decode.Consumer, so it can share a pass over a waveform with protocol decoders
+type Checker struct {
+	Clock      string
+	Falling    bool // Sample at falling instead of rising edges
+	Context    int  // Cycles shown before the start and after the end of a failure
+	Assertions []Assertion
+	Results    []Result // Set by Finish
+
+	inputs  []string
+	time    int64
+	current []string // Values after the changes at time
+	settled []string // Values before the changes at time
+	trace   trace
+}
+
+// Creates a checker sampling at the rising edges of clock, showing 2 cycles of context with failures
+func NewChecker(clock string, assertions ...Assertion) *Checker {
+	checker := &Checker{Clock: clock, Context: 2, Assertions: assertions}
+	names := make(map[string]bool)
+	for _, assertion := range assertions {
+		for _, name := range assertion.Property.signals() {
+			if name != clock {
+				names[name] = true
+			}
+		}
+	}
+	checker.inputs = []string{clock}
+	for name := range names {
+		checker.inputs = append(checker.inputs, name)
+	}
+	sort.Strings(checker.inputs[1:])
+	checker.trace.index = make(map[string]int)
+	for i, name := range checker.inputs {
+		checker.trace.index[name] = i
+		checker.current = append(checker.current, "x")
+		checker.settled = append(checker.settled, "x")
+	}
+	return checker
+}
+
+// Checks assertions against a waveform, see Checker
+func Check(reader vcd.WaveReader, clock string, assertions ...Assertion) ([]Result, error) {
+	checker := NewChecker(clock, assertions...)
+	if err := decode.Run(reader, checker); err != nil {
+		return nil, err
+	}
+	return checker.Results, nil
+}
+
+func (checker *Checker) Inputs() []string {
+	return checker.inputs
+}
+
+func (checker *Checker) Change(time int64, input int, value string) {
+	if time != checker.time {
+		checker.settle()
+		checker.time = time
+	}
+	checker.current[input] = value
+}
+
+// Samples the settled values when the changes at the current time contain a clock edge
+func (checker *Checker) settle() {
+	edge := byte('1')
+	if checker.Falling {
+		edge = '0'
+	}
+	if lsb(checker.current[0]) == edge && lsb(checker.settled[0]) != edge {
+		checker.trace.times = append(checker.trace.times, checker.time)
+		checker.trace.values = append(checker.trace.values, append([]string(nil), checker.settled...))
+	}
+	copy(checker.settled, checker.current)
+}
+
+func (checker *Checker) Finish(time int64) {
+	checker.settle()
+	checker.Results = nil
+	for _, assertion := range checker.Assertions {
+		result := Result{Assertion: assertion.Name, Property: assertion.Property.String()}
+		for cycle := range checker.trace.times {
+			verdict, end := assertion.Property.check(&checker.trace, cycle)
+			if verdict != vacuous {
+				result.Attempts++
+			}
+			switch verdict {
+			case pass:
+				result.Passes++
+			case pending:
+				result.Pending++
+			case fail:
+				result.Failures = append(result.Failures, Failure{
+					Start:   checker.trace.times[cycle],
+					End:     checker.trace.times[end],
+					Excerpt: checker.excerpt(assertion.Property.signals(), cycle, end),
+				})
+			}
+		}
+		checker.Results = append(checker.Results, result)
+	}
+}
+
+// Returns a table of the sampled values of signals, one column per cycle
+func (checker *Checker) excerpt(signals []string, start int, end int) string {
+	first, last := max(start-checker.Context, 0), min(end+checker.Context, len(checker.trace.times)-1)
+	rows := [][]string{{"time"}}
+	seen := make(map[string]bool)
+	for _, name := range signals {
+		if !seen[name] {
+			seen[name] = true
+			rows = append(rows, []string{name})
+		}
+	}
+	for cycle := first; cycle <= last; cycle++ {
+		rows[0] = append(rows[0], fmt.Sprint(checker.trace.times[cycle]))
+		for i := 1; i < len(rows); i++ {
+			rows[i] = append(rows[i], checker.trace.sample(rows[i][0], cycle))
+		}
+	}
+	widths := make([]int, len(rows[0]))
+	for _, row := range rows {
+		for i, cell := range row {
+			widths[i] = max(widths[i], len(cell))
+		}
+	}
+	var text strings.Builder
+	for _, row := range rows {
+		for i, cell := range row {
+			if i == 0 {
+				fmt.Fprintf(&text, "%-*s", widths[i], cell)
+			} else {
+				fmt.Fprintf(&text, " %*s", widths[i], cell)
+			}
+		}
+		text.WriteString("\n")
+	}
+	return text.String()
+}
@@ -0,0 +1,498 @@
+package decode
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Signal and bit timing of a CAN bus
+type CanConfig struct {
+	Signal      string // Receive line, recessive is 1
+	BitTime     int64  // Time units per bit of the arbitration phase, detected from the shortest pulse of the arbitration phases when 0
+	DataBitTime int64  // Time units per bit of the data phase of CAN FD frames with bit rate switch, BitTime when 0
+	FD          bool   // A recessive FDF bit marks a CAN FD frame instead of a reserved bit
+}
+
+// Field of a CAN frame, from the start of its first bit to the end of its last bit
+type CanField struct {
+	Name  string // Such as SOF, ID, RTR, IDE, DLC, Data, CRC, ACK or EOF
+	Start int64
+	End   int64
+	Value uint64
+}
+
+// Frame received on a CAN bus
+type CanFrame struct {
+	Start          int64
+	End            int64
+	ID             uint32
+	Extended       bool
+	Remote         bool
+	FD             bool
+	BitRateSwitch  bool
+	ErrorIndicator bool // ESI bit of CAN FD frames
+	DLC            int
+	Data           []byte
+	CRC            uint32
+	CRCValid       bool
+	Ack            bool
+	Error          string // Empty for valid frames, such as stuff error, form error, crc error or error frame
+	Fields         []CanField
+}
+
+// Decoder for CAN 2.0A/B and CAN FD buses, see CanConfig
+// Frames are decoded by Finish, as detecting the bit time needs all edges of the line
+type Can struct {
+	Frames  []CanFrame
+	BitTime int64 // Configured or detected time units per bit of the arbitration phase
+
+	config CanConfig
+	times  []int64
+	levels []bool
+}
+
+// Data lengths of the DLC values of CAN FD frames
+var canFdLengths = []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 12, 16, 20, 24, 32, 48, 64}
+
+// Creates a decoder for the bus described by config
+func NewCan(config CanConfig) (*Can, error) {
+	if config.Signal == "" {
+		return nil, errors.New("can: a signal is required")
+	}
+	if config.BitTime < 0 || config.DataBitTime < 0 {
+		return nil, fmt.Errorf("can: invalid bit times %d %d", config.BitTime, config.DataBitTime)
+	}
+	return &Can{config: config, BitTime: config.BitTime}, nil
+}
+
+func (can *Can) Inputs() []string {
+	return []string{can.config.Signal}
+}
+
+func (can *Can) Change(time int64, input int, value string) {
+	level, known := Level(value)
+	if !known || len(can.levels) > 0 && can.levels[len(can.levels)-1] == level {
+		return
+	}
+	can.times = append(can.times, time)
+	can.levels = append(can.levels, level)
+}
+
+// Returns the level at time, the line is recessive before the first change
+func (can *Can) level(time int64) bool {
+	i := sort.Search(len(can.times), func(i int) bool { return can.times[i] > time })
+	return i == 0 || can.levels[i-1]
+}
+
+// Returns the time of the first change after time to the given level, or -1
+func (can *Can) nextEdge(time int64, level bool) int64 {
+	i := sort.Search(len(can.times), func(i int) bool { return can.times[i] > time })
+	for ; i < len(can.times); i++ {
+		if can.levels[i] == level {
+			return can.times[i]
+		}
+	}
+	return -1
+}
+
+// Computes the CRC of CAN frames over bits, width is 15 for classic frames and 17 or 21 for CAN FD frames
+func canCrc(bits []bool, width uint, poly uint32, init uint32) uint32 {
+	mask := uint32(1)<<width - 1
+	crc := init
+	for _, bit := range bits {
+		feedback := bit != (crc>>(width-1)&1 == 1)
+		crc = crc << 1 & mask
+		if feedback {
+			crc ^= poly & mask
+		}
+	}
+	return crc
+}
+
+// Reads the bits of a frame, resynchronizing on edges and removing stuff bits
+type canReader struct {
+	can      *Can
+	end      int64 // Time of the last change of the waveform
+	bitStart float64
+	bitTime  float64
+	previous bool // Level of the last bit on the line, including stuff bits
+
+	stuffing  bool
+	run       int
+	stuffed   []bool // Bits from SOF including dynamic stuff bits, for the CRC of CAN FD frames
+	destuffed []bool // Bits from SOF without stuff bits, for the CRC of classic frames
+	stuffBits int
+	fixed     int // Bits read since the last fixed stuff bit of a CAN FD CRC field
+	err       string
+
+	frame *CanFrame
+}
+
+func (r *canReader) fail(err string) {
+	if r.err == "" {
+		r.err = err
+	}
+}
+
+// Reads a bit as it is on the line, sampled in the middle of the bit
+func (r *canReader) raw() bool {
+	// Resynchronize on an edge within half a bit of the expected start
+	lo := r.bitStart - r.bitTime/2
+	i := sort.Search(len(r.can.times), func(i int) bool { return float64(r.can.times[i]) > lo })
+	if i < len(r.can.times) && float64(r.can.times[i]) < r.bitStart+r.bitTime/2 {
+		r.bitStart = float64(r.can.times[i])
+	}
+	sample := int64(math.Round(r.bitStart + r.bitTime/2))
+	if sample > r.end {
+		r.fail("incomplete frame")
+	}
+	r.previous = r.can.level(sample)
+	r.bitStart += r.bitTime
+	return r.previous
+}
+
+// Switches to another bit time at the sample point of the last bit, for the bit rate switch of CAN FD frames
+func (r *canReader) switchBitTime(bitTime float64) {
+	r.bitStart += bitTime/2 - r.bitTime/2
+	r.bitTime = bitTime
+}
+
+// Reads a bit, removing the stuff bit after five equal bits while stuffing is enabled
+func (r *canReader) bit() bool {
+	level := r.raw()
+	if !r.stuffing {
+		return level
+	}
+	r.stuffed = append(r.stuffed, level)
+	r.destuffed = append(r.destuffed, level)
+	if len(r.stuffed) > 1 && level == r.stuffed[len(r.stuffed)-2] {
+		r.run++
+	} else {
+		r.run = 1
+	}
+	if r.run == 5 {
+		stuff := r.raw()
+		r.stuffed = append(r.stuffed, stuff)
+		r.stuffBits++
+		if stuff == level {
+			r.fail("stuff error")
+		}
+		r.run = 1
+	}
+	return level
+}
+
+// Reads a bit of the CRC field of CAN FD frames, which has a fixed stuff bit before every four bits
+func (r *canReader) fixedBit() bool {
+	if r.fixed%4 == 0 {
+		previous := r.previous
+		if r.raw() == previous {
+			r.fail("stuff error")
+		}
+	}
+	r.fixed++
+	return r.raw()
+}
+
+// Reads a field of bits, most significant bit first
+// Nothing is read after an error, the reader stays where the error was detected
+func (r *canReader) field(name string, bits int, read func() bool) uint64 {
+	if r.err != "" {
+		return 0
+	}
+	start := r.bitStart
+	value := uint64(0)
+	for i := 0; i < bits; i++ {
+		value <<= 1
+		if read() {
+			value |= 1
+		}
+	}
+	r.frame.Fields = append(r.frame.Fields, CanField{Name: name, Start: int64(math.Round(start)), End: int64(math.Round(r.bitStart)), Value: value})
+	return value
+}
+
+// Decodes the frame starting with the start of frame bit at sof
+// Frames with an error end where the error was detected
+func (can *Can) decodeFrame(sof int64, end int64) CanFrame {
+	frame := CanFrame{Start: sof}
+	nominal := float64(can.BitTime)
+	data := nominal
+	if can.config.DataBitTime > 0 {
+		data = float64(can.config.DataBitTime)
+	}
+	r := &canReader{can: can, end: end, bitStart: float64(sof), bitTime: nominal, previous: true, stuffing: true, frame: &frame}
+
+	if can.errorFlag(sof, end) {
+		return can.errorFrame(sof, end)
+	}
+
+	r.field("SOF", 1, r.bit)
+	id := r.field("ID", 11, r.bit)
+	rtr := r.field("RTR", 1, r.bit)
+	frame.Extended = r.field("IDE", 1, r.bit) == 1
+	if frame.Extended {
+		// The first RTR bit is the SRR bit of extended frames, the identifier continues after IDE
+		r.frame.Fields[len(r.frame.Fields)-2].Name = "SRR"
+		id = id<<18 | r.field("ID", 18, r.bit)
+		rtr = r.field("RTR", 1, r.bit)
+	}
+	frame.ID = uint32(id)
+	frame.FD = r.field("FDF", 1, r.bit) == 1 && can.config.FD
+	if !frame.FD {
+		// Classic frames have reserved bits instead
+		r.frame.Fields[len(r.frame.Fields)-1].Name = "r0"
+		if frame.Extended {
+			r.frame.Fields[len(r.frame.Fields)-1].Name = "r1"
+		}
+	}
+	if frame.FD {
+		r.field("res", 1, r.bit)
+		frame.BitRateSwitch = r.field("BRS", 1, r.bit) == 1
+		if frame.BitRateSwitch {
+			r.switchBitTime(data)
+		}
+		frame.ErrorIndicator = r.field("ESI", 1, r.bit) == 1
+	} else {
+		frame.Remote = rtr == 1
+		if frame.Extended {
+			r.field("r0", 1, r.bit)
+		}
+	}
+	frame.DLC = int(r.field("DLC", 4, r.bit))
+	length := min(frame.DLC, 8)
+	if frame.FD {
+		length = canFdLengths[frame.DLC]
+	} else if frame.Remote {
+		length = 0
+	}
+	for i := 0; i < length && r.err == ""; i++ {
+		frame.Data = append(frame.Data, byte(r.field("Data", 8, r.bit)))
+	}
+
+	if frame.FD {
+		width, poly := uint(17), uint32(0x3685B)
+		if length > 16 {
+			width, poly = 21, 0x302899
+		}
+		r.stuffing = false
+		stuffCount := r.field("SC", 4, r.fixedBit)
+		gray := uint64(r.stuffBits%8) ^ uint64(r.stuffBits%8)>>1
+		parity := (gray ^ gray>>1 ^ gray>>2) & 1
+		if stuffCount != gray<<1|parity {
+			r.fail("stuff count error")
+		}
+		bits := append([]bool(nil), r.stuffed...)
+		for i := 3; i >= 0; i-- {
+			bits = append(bits, stuffCount>>uint(i)&1 == 1)
+		}
+		expected := canCrc(bits, width, poly, 1<<(width-1))
+		frame.CRC = uint32(r.field("CRC", int(width), r.fixedBit))
+		frame.CRCValid = frame.CRC == expected
+	} else {
+		expected := canCrc(r.destuffed, 15, 0x4599, 0)
+		frame.CRC = uint32(r.field("CRC", 15, r.bit))
+		frame.CRCValid = frame.CRC == expected
+		r.stuffing = false
+	}
+	if r.err == "" && !frame.CRCValid {
+		r.fail("crc error")
+	}
+	if r.field("CRC delimiter", 1, r.raw) != 1 {
+		r.fail("form error")
+	}
+	if frame.BitRateSwitch {
+		r.switchBitTime(nominal)
+	}
+	frame.Ack = r.field("ACK", 1, r.raw) == 0
+	if r.field("ACK delimiter", 1, r.raw) != 1 {
+		r.fail("form error")
+	}
+	if r.field("EOF", 7, r.raw) != 1<<7-1 {
+		r.fail("form error")
+	}
+	frame.End = int64(math.Round(r.bitStart))
+	frame.Error = r.err
+	return frame
+}
+
+// Returns true when the line is dominant for six bits from time, which breaks the stuffing rule
+// Nodes send such an error flag when they detect an error
+func (can *Can) errorFlag(time int64, end int64) bool {
+	rising := can.nextEdge(time, true)
+	if rising < 0 {
+		rising = end
+	}
+	return rising-time >= 6*can.BitTime
+}
+
+func (can *Can) errorFrame(time int64, end int64) CanFrame {
+	rising := can.nextEdge(time, true)
+	if rising < 0 {
+		rising = end
+	}
+	return CanFrame{Start: time, End: rising, Error: "error frame"}
+}
+
+// Returns the time after an error at which the bus has been recessive for eleven bits
+// Error flags sent until then are added as error frames
+func (can *Can) recover(time int64, end int64) int64 {
+	for {
+		rising := time
+		if !can.level(time) {
+			if rising = can.nextEdge(time, true); rising < 0 {
+				return end
+			}
+		}
+		falling := can.nextEdge(rising, false)
+		if falling < 0 || falling-rising >= 11*can.BitTime {
+			return rising + 11*can.BitTime
+		}
+		if can.errorFlag(falling, end) {
+			can.Frames = append(can.Frames, can.errorFrame(falling, end))
+		}
+		time = falling
+	}
+}
+
+// Returns the shortest pulse within the first bits after the start of frame at index sof, 0 when there is none
+// The first pulse holds at most five bits because of stuffing, so three times its length ends before the BRS bit
+func (can *Can) arbitrationPulse(sof int) int64 {
+	if sof+1 >= len(can.times) {
+		return 0
+	}
+	end := can.times[sof] + 3*(can.times[sof+1]-can.times[sof])
+	shortest := int64(0)
+	for i := sof + 1; i < len(can.times) && can.times[i] <= end; i++ {
+		if pulse := can.times[i] - can.times[i-1]; shortest == 0 || pulse < shortest {
+			shortest = pulse
+		}
+	}
+	return shortest
+}
+
+// Returns the shortest pulse on the line as the bit time
+// With a bit rate switch only the arbitration phases are measured, as the data phase has shorter bits
+func (can *Can) detectBitTime() int64 {
+	shortest := int64(0)
+	if can.config.DataBitTime == 0 {
+		for i := 2; i < len(can.times); i++ {
+			if pulse := can.times[i] - can.times[i-1]; shortest == 0 || pulse < shortest {
+				shortest = pulse
+			}
+		}
+		return shortest
+	}
+	first := 1
+	for first < len(can.times) && can.levels[first] {
+		first++
+	}
+	if first >= len(can.times) {
+		return 0
+	}
+	// The first frame gives an upper bound of the bit time, frames start after at least eleven recessive bits of it
+	shortest = can.arbitrationPulse(first)
+	bound := shortest
+	for i := first + 2; i < len(can.times); i++ {
+		if can.levels[i] || can.times[i]-can.times[i-1] < 11*bound {
+			continue
+		}
+		if pulse := can.arbitrationPulse(i); pulse > 0 && pulse < shortest {
+			shortest = pulse
+		}
+	}
+	return shortest
+}
+
+func (can *Can) Finish(time int64) {
+	if can.BitTime == 0 {
+		can.BitTime = can.detectBitTime()
+	}
+	if can.BitTime == 0 {
+		return
+	}
+	idle := int64(math.MinInt64)
+	for i, t := range can.times {
+		if can.levels[i] || t < idle || i == 0 {
+			continue
+		}
+		frame := can.decodeFrame(t, time)
+		can.Frames = append(can.Frames, frame)
+		idle = frame.End
+		if frame.Error != "" {
+			idle = can.recover(frame.End, time)
+		}
+	}
+}
+
+// Returns every frame as an event with a CanFrame
+func (can *Can) Events() []Event {
+	var events []Event
+	for _, frame := range can.Frames {
+		events = append(events, Event{Start: frame.Start, End: frame.End, Data: frame})
+	}
+	return events
+}
+
+// Returns an annotation for every field of every frame, such as ID 123, DLC 2, Data 12 and CRC ok
+func (can *Can) Annotations() []Annotation {
+	var annotations []Annotation
+	for _, frame := range can.Frames {
+		for _, field := range frame.Fields {
+			text := field.Name
+			switch field.Name {
+			case "ID":
+				text = fmt.Sprintf("ID %X", field.Value)
+			case "DLC":
+				text = fmt.Sprintf("DLC %d", field.Value)
+			case "Data":
+				text = fmt.Sprintf("%02X", field.Value)
+			case "CRC":
+				text = fmt.Sprintf("CRC %X", field.Value)
+				if !frame.CRCValid {
+					text += " bad"
+				}
+			case "ACK":
+				text = ackText(field.Value == 0)
+			case "SOF", "EOF":
+			default:
+				continue
+			}
+			annotations = append(annotations, Annotation{Start: field.Start, End: field.End, Text: text})
+		}
+	}
+	return annotations
+}
+
+// Returns an annotation for every frame, such as 123 [2] 12 34 or the error of the frame
+func (can *Can) FrameAnnotations() []Annotation {
+	var annotations []Annotation
+	for _, frame := range can.Frames {
+		text := fmt.Sprintf("%03X [%d]", frame.ID, len(frame.Data))
+		if frame.Extended {
+			text = fmt.Sprintf("%08X [%d]", frame.ID, len(frame.Data))
+		}
+		if frame.Remote {
+			text += " remote"
+		}
+		for _, b := range frame.Data {
+			text += fmt.Sprintf(" %02X", b)
+		}
+		if frame.Error != "" {
+			text = frame.Error
+		}
+		end := frame.End
+		if end <= frame.Start {
+			end = frame.Start + 6*can.BitTime
+		}
+		annotations = append(annotations, Annotation{Start: frame.Start, End: end, Text: text})
+	}
+	return annotations
+}
+
+// Returns the tracks to write with WriteScope, fields and frames
+func (can *Can) Tracks() map[string][]Annotation {
+	return map[string][]Annotation{"fields": can.Annotations(), "frames": can.FrameAnnotations()}
+}
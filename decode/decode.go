@@ -0,0 +1,166 @@
+// Package decode decodes protocols from the signals of a waveform
+//
+// Decoders implement Consumer and are fed with the value changes of a vcd.WaveReader by Run,
+// several decoders can share a single pass over the file
+// A Session also feeds stacked decoders, such as Eeprom on I2c, with the events of the decoders below them
+package decode
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/elamre/vcd"
+)
+
+// Consumer of value changes, such as a protocol decoder
+type Consumer interface {
+	// Full names of the variables to consume, such as example.logic.mosi
+	Inputs() []string
+	// Called for every value change of an input, in time order
+	// Input is the index in Inputs, value is a bit such as 1 or x, a binary vector, a real or a string
+	Change(time int64, input int, value string)
+	// Called once after the last value change, with the time of the last timestamp
+	Finish(time int64)
+}
+
+// Feeds the value changes of reader to the consumers
+// The header is parsed first when that has not happened yet
+// Returns an error without reading value changes when an input does not exist
+func Run(reader vcd.WaveReader, consumers ...Consumer) error {
+	if len(reader.GetIdentifiers()) == 0 {
+		reader.ParseHeader()
+	}
+	identifiers := make(map[string]string)
+	var names []string
+	for identifier, variable := range reader.GetIdentifiers() {
+		identifiers[variable.VariableName] = identifier
+		names = append(names, variable.VariableName)
+	}
+	sort.Strings(names)
+
+	type target struct {
+		consumer Consumer
+		input    int
+	}
+	targets := make(map[string][]target)
+	for _, consumer := range consumers {
+		for input, name := range consumer.Inputs() {
+			if name == "" {
+				continue
+			}
+			identifier, ok := identifiers[name]
+			if !ok {
+				return fmt.Errorf("unknown input %s, the waveform has %s", name, strings.Join(names, " "))
+			}
+			targets[identifier] = append(targets[identifier], target{consumer, input})
+		}
+	}
+
+	last := int64(0)
+	for {
+		valid, time, identifier, value := reader.Next()
+		if !valid {
+			break
+		}
+		last = time
+		for _, t := range targets[identifier] {
+			t.consumer.Change(time, t.input, formatValue(value))
+		}
+	}
+	for _, consumer := range consumers {
+		consumer.Finish(last)
+	}
+	return nil
+}
+
+func formatValue(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case vcd.EvcdPortValue:
+		return v.Value()
+	}
+	return fmt.Sprint(value)
+}
+
+// Returns the level of a single bit value such as 1, b1 or x
+// Returns false for x, z and other unknown levels
+func Level(value string) (bool, bool) {
+	if value == "" {
+		return false, false
+	}
+	switch value[len(value)-1] {
+	case '0':
+		return false, true
+	case '1':
+		return true, true
+	}
+	return false, false
+}
+
+// Decoded item shown over a time span, such as a byte of a transaction
+type Annotation struct {
+	Start int64
+	End   int64
+	Text  string
+}
+
+// Value written to a string variable for an annotation
+type annotationChange struct {
+	time     int64
+	text     string
+	variable string
+}
+
+// Returns the changes showing annotations on a variable, which is cleared between annotations
+func annotationChanges(variable string, annotations []Annotation) []annotationChange {
+	sorted := append([]Annotation(nil), annotations...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Start < sorted[j].Start })
+	var changes []annotationChange
+	for i, annotation := range sorted {
+		// Annotations of a single instant, such as a word of one bit, are shown for one time unit
+		end := max(annotation.End, annotation.Start+1)
+		changes = append(changes, annotationChange{annotation.Start, annotation.Text, variable})
+		if i == len(sorted)-1 || sorted[i+1].Start > end {
+			changes = append(changes, annotationChange{end, "", variable})
+		}
+	}
+	return changes
+}
+
+func writeChanges(writer vcd.WaveWriter, changes []annotationChange) error {
+	sort.SliceStable(changes, func(i, j int) bool { return changes[i].time < changes[j].time })
+	for _, change := range changes {
+		if err := writer.SetValue(uint64(change.time), change.text, change.variable); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Writes annotations to a string variable, which is cleared between annotations
+// Annotations are written in order of their start, writer must not be at a later time than the first start
+func WriteAnnotations(writer vcd.WaveWriter, variable string, annotations []Annotation) error {
+	return writeChanges(writer, annotationChanges(variable, annotations))
+}
+
+// Registers a string variable in module for every track and writes its annotations
+// The changes of all tracks are written in time order, writer must not be at a later time than the first start
+func WriteScope(writer vcd.WaveWriter, module string, tracks map[string][]Annotation) error {
+	var names []string
+	for name := range tracks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var variables []vcd.VcdDataType
+	var changes []annotationChange
+	for _, name := range names {
+		variables = append(variables, vcd.NewVariable(name, "string", 1))
+		changes = append(changes, annotationChanges(name, tracks[name])...)
+	}
+	if _, err := writer.RegisterVariables(module, variables...); err != nil {
+		return err
+	}
+	return writeChanges(writer, changes)
+}
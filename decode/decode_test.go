@@ -0,0 +1,568 @@
+package decode
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/elamre/vcd"
+	"github.com/elamre/vcd/internal/vcdtest"
+)
+
+// Change of a signal of module top, see vcdtest.Write
+type change = vcdtest.Change
+
+// Returns the changes to shift words over spi mode 0, msb first, with a clock period of 10
+func spiChanges(start uint64, mosi []uint64, miso []uint64) []change {
+	changes := []change{{Time: start, Signal: "cs", Value: "0"}}
+	time := start + 5
+	for i := range mosi {
+		for bit := 7; bit >= 0; bit-- {
+			changes = append(changes,
+				change{Time: time, Signal: "mosi", Value: string(rune('0' + mosi[i]>>bit&1))},
+				change{Time: time, Signal: "miso", Value: string(rune('0' + miso[i]>>bit&1))},
+				change{Time: time + 5, Signal: "clk", Value: "1"},
+				change{Time: time + 10, Signal: "clk", Value: "0"},
+			)
+			time += 10
+		}
+	}
+	return append(changes, change{Time: time + 5, Signal: "cs", Value: "1"})
+}
+
+func TestSpi(t *testing.T) {
+	changes := append([]change{{Time: 0, Signal: "cs", Value: "1"}, {Time: 0, Signal: "clk", Value: "0"}}, spiChanges(10, []uint64{0xA5, 0x01}, []uint64{0x3C, 0xFF})...)
+	reader := vcdtest.Write(t, "1ns", []string{"clk", "mosi", "miso", "cs"}, changes)
+	spi, e := NewSpi(SpiConfig{Clock: "top.clk", Mosi: "top.mosi", Miso: "top.miso", Select: "top.cs"})
+	vcdtest.Check(t, e)
+	vcdtest.Check(t, Run(reader, spi))
+
+	if len(spi.Transactions) != 1 || len(spi.Transactions[0].Words) != 2 {
+		t.Fatalf("unexpected transactions %+v", spi.Transactions)
+	}
+	transaction := spi.Transactions[0]
+	if transaction.Start != 10 || transaction.End != 180 {
+		t.Fatalf("unexpected transaction times %+v", transaction)
+	}
+	words := transaction.Words
+	if words[0].Mosi != 0xA5 || words[0].Miso != 0x3C || words[1].Mosi != 0x01 || words[1].Miso != 0xFF || words[0].Start != 20 || words[0].End != 90 {
+		t.Fatalf("unexpected words %+v", words)
+	}
+
+	lsbFirst, e := NewSpi(SpiConfig{Clock: "top.clk", Mosi: "top.mosi", Select: "top.cs", LsbFirst: true})
+	vcdtest.Check(t, e)
+	vcdtest.Check(t, Run(vcdtest.Write(t, "1ns", []string{"clk", "mosi", "miso", "cs"}, changes), lsbFirst))
+	if lsbFirst.Transactions[0].Words[1].Mosi != 0x80 {
+		t.Fatalf("unexpected lsb first word %+v", lsbFirst.Transactions[0].Words[1])
+	}
+
+	if _, e = NewSpi(SpiConfig{Clock: "top.clk"}); e == nil {
+		t.Fatal("expected an error without data signals")
+	}
+	if _, e = NewSpi(SpiConfig{Clock: "top.clk", Mosi: "top.mosi"}); e == nil {
+		t.Fatal("expected an error without select and idle gap")
+	}
+	missing, _ := NewSpi(SpiConfig{Clock: "top.sclk", Mosi: "top.mosi", IdleGap: 20})
+	if Run(vcdtest.Write(t, "1ns", []string{"clk", "mosi"}, []change{{Time: 0, Signal: "clk", Value: "0"}}), missing) == nil {
+		t.Fatal("expected an error for an unknown input")
+	}
+
+	// Without select, the clock pause between the transactions splits them
+	unselected := append(spiChanges(10, []uint64{0xA5}, []uint64{0x3C}), spiChanges(200, []uint64{0x01}, []uint64{0xFF})...)
+	idle, e := NewSpi(SpiConfig{Clock: "top.clk", Mosi: "top.mosi", Miso: "top.miso", IdleGap: 20})
+	vcdtest.Check(t, e)
+	vcdtest.Check(t, Run(vcdtest.Write(t, "1ns", []string{"clk", "mosi", "miso", "cs"}, append([]change{{Time: 0, Signal: "clk", Value: "0"}}, unselected...)), idle))
+	if len(idle.Transactions) != 2 || idle.Transactions[0].End != 95 || idle.Transactions[1].Start != 210 ||
+		idle.Transactions[1].Words[0].Mosi != 0x01 || idle.Transactions[1].Words[0].Miso != 0xFF {
+		t.Fatalf("unexpected transactions %+v", idle.Transactions)
+	}
+
+	// A dump starting with the clock high has no edge at its start
+	inverted, e := NewSpi(SpiConfig{Clock: "top.clk", Mosi: "top.mosi", IdleGap: 20})
+	vcdtest.Check(t, e)
+	startHigh := append([]change{{Time: 0, Signal: "clk", Value: "1"}, {Time: 5, Signal: "clk", Value: "0"}}, spiChanges(10, []uint64{0xA5}, []uint64{0x3C})...)
+	vcdtest.Check(t, Run(vcdtest.Write(t, "1ns", []string{"clk", "mosi", "miso", "cs"}, startHigh), inverted))
+	if len(inverted.Transactions) != 1 || len(inverted.Transactions[0].Words) != 1 || inverted.Transactions[0].Words[0].Mosi != 0xA5 {
+		t.Fatalf("unexpected transactions %+v", inverted.Transactions)
+	}
+}
+
+func TestWriteAnnotations(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "annotations.vcd")
+	writer, e := vcd.New(filename, "1ns")
+	vcdtest.Check(t, e)
+	_, e = writer.RegisterVariables("top", vcd.NewVariable("spi", "string", 1))
+	vcdtest.Check(t, e)
+	vcdtest.Check(t, WriteAnnotations(&writer, "spi", []Annotation{{20, 90, "A5/3C"}, {100, 170, "01/FF"}, {170, 200, "next"}, {250, 250, "bit"}}))
+	writer.Close()
+
+	reader, e := vcd.NewReader(filename)
+	vcdtest.Check(t, e)
+	defer reader.Close()
+	values := reader.ReadAll()["top.spi"]
+	expected := []vcd.ReadValue{{Time: 20, Value: "A5/3C"}, {Time: 90, Value: ""}, {Time: 100, Value: "01/FF"},
+		{Time: 170, Value: "next"}, {Time: 200, Value: ""}, {Time: 250, Value: "bit"}, {Time: 251, Value: ""}}
+	if len(values) != len(expected) {
+		t.Fatalf("unexpected values %v", values)
+	}
+	for i := range expected {
+		if values[i] != expected[i] {
+			t.Fatalf("expected %v got %v", expected[i], values[i])
+		}
+	}
+}
+
+// Returns the changes to send frames of 8 data bits, a parity bit when parity is not nil, and a stop bit
+func uartChanges(bitTime uint64, data []uint64, parity []int, stop []int) []change {
+	changes := []change{{Time: 0, Signal: "tx", Value: "1"}}
+	time := bitTime * 3
+	for i, value := range data {
+		bits := []uint64{0}
+		for bit := 0; bit < 8; bit++ {
+			bits = append(bits, value>>bit&1)
+		}
+		if parity != nil {
+			bits = append(bits, uint64(parity[i]))
+		}
+		bits = append(bits, uint64(stop[i]), 1)
+		for _, bit := range bits {
+			changes = append(changes, change{Time: time, Signal: "tx", Value: string(rune('0' + bit))})
+			time += bitTime
+		}
+		time += bitTime * 2
+	}
+	return changes
+}
+
+func TestUart(t *testing.T) {
+	uart, e := NewUart(UartConfig{Signal: "top.tx"})
+	vcdtest.Check(t, e)
+	vcdtest.Check(t, Run(vcdtest.Write(t, "1ns", []string{"tx"}, uartChanges(100, []uint64{0x41, 0x55}, nil, []int{1, 1})), uart))
+	if uart.BitTime != 100 || len(uart.Frames) != 2 || uart.Frames[0].Data != 0x41 || uart.Frames[1].Data != 0x55 {
+		t.Fatalf("unexpected frames %+v with bit time %d", uart.Frames, uart.BitTime)
+	}
+	if uart.Frames[0].Start != 300 || uart.Frames[0].End != 1300 || uart.Frames[0].FramingError {
+		t.Fatalf("unexpected first frame %+v", uart.Frames[0])
+	}
+	if baud, _ := uart.Baud("1ns"); baud != 1e7 {
+		t.Fatalf("unexpected baud rate %f", baud)
+	}
+
+	bitTime, e := BitTime(1e7, "1ns")
+	vcdtest.Check(t, e)
+	uart, e = NewUart(UartConfig{Signal: "top.tx", BitTime: bitTime, Parity: ParityEven})
+	vcdtest.Check(t, e)
+	vcdtest.Check(t, Run(vcdtest.Write(t, "1ns", []string{"tx"}, uartChanges(100, []uint64{0x41, 0x43, 0x01}, []int{0, 0, 1}, []int{1, 1, 0})), uart))
+	if len(uart.Frames) != 3 || uart.Frames[0].ParityError || !uart.Frames[1].ParityError || !uart.Frames[2].FramingError {
+		t.Fatalf("unexpected frames %+v", uart.Frames)
+	}
+	annotations := uart.Annotations()
+	if annotations[1].Text != "43 parity error" || annotations[2].Text != "01 framing error" {
+		t.Fatalf("unexpected annotations %+v", annotations)
+	}
+}
+
+// Builds I2C changes with a clock period of 10, clock and data start high
+type i2cBus struct {
+	time    uint64
+	changes []change
+}
+
+func (bus *i2cBus) start() {
+	bus.changes = append(bus.changes, change{Time: bus.time, Signal: "sda", Value: "1"}, change{Time: bus.time + 2, Signal: "scl", Value: "1"}, change{Time: bus.time + 5, Signal: "sda", Value: "0"}, change{Time: bus.time + 8, Signal: "scl", Value: "0"})
+	bus.time += 10
+}
+
+func (bus *i2cBus) stop() {
+	bus.changes = append(bus.changes, change{Time: bus.time, Signal: "sda", Value: "0"}, change{Time: bus.time + 2, Signal: "scl", Value: "1"}, change{Time: bus.time + 5, Signal: "sda", Value: "1"})
+	bus.time += 10
+}
+
+func (bus *i2cBus) byte(value uint64, ack bool) {
+	for bit := 8; bit >= 0; bit-- {
+		level := uint64(1)
+		if bit > 0 {
+			level = value >> (bit - 1) & 1
+		} else if ack {
+			level = 0
+		}
+		bus.changes = append(bus.changes, change{Time: bus.time, Signal: "sda", Value: string(rune('0' + level))}, change{Time: bus.time + 5, Signal: "scl", Value: "1"}, change{Time: bus.time + 8, Signal: "scl", Value: "0"})
+		bus.time += 10
+	}
+}
+
+func TestI2c(t *testing.T) {
+	bus := &i2cBus{changes: []change{{Time: 0, Signal: "scl", Value: "1"}, {Time: 0, Signal: "sda", Value: "1"}}, time: 10}
+	bus.start()
+	bus.byte(0x50<<1, true)
+	bus.byte(0x12, true)
+	bus.start()
+	bus.byte(0x50<<1|1, true)
+	bus.byte(0x34, false)
+	bus.stop()
+	bus.start()
+	bus.byte(0xF0|0x2<<1, true)
+	bus.byte(0xA5, true)
+	bus.start()
+	bus.byte(0xF0|0x2<<1|1, false)
+	bus.stop()
+	i2c, e := NewI2c(I2cConfig{Clock: "top.scl", Data: "top.sda"})
+	vcdtest.Check(t, e)
+	vcdtest.Check(t, Run(vcdtest.Write(t, "1ns", []string{"scl", "sda"}, bus.changes), i2c))
+
+	transactions := i2c.Transactions
+	if len(transactions) != 4 {
+		t.Fatalf("unexpected transactions %+v", transactions)
+	}
+	if transactions[0].Address != 0x50 || transactions[0].Read || !transactions[0].AddressAck() || transactions[0].Stopped || transactions[0].Data[0].Value != 0x12 {
+		t.Fatalf("unexpected write %+v", transactions[0])
+	}
+	if !transactions[1].RepeatedStart || !transactions[1].Read || !transactions[1].Stopped || transactions[1].Data[0].Value != 0x34 || transactions[1].Data[0].Ack {
+		t.Fatalf("unexpected read %+v", transactions[1])
+	}
+	if !transactions[2].TenBit || transactions[2].Address != 0x2A5 || transactions[3].Address != 0x2A5 || transactions[3].AddressAck() {
+		t.Fatalf("unexpected 10 bit transactions %+v", transactions[2:])
+	}
+	annotations := i2c.TransactionAnnotations()
+	if annotations[0].Text != "S W 50: 12" || annotations[1].Text != "Sr R 50: 34 P" || annotations[3].Text != "Sr R 2A5: P" {
+		t.Fatalf("unexpected annotations %+v", annotations)
+	}
+
+	filename := filepath.Join(t.TempDir(), "i2c.vcd")
+	writer, e := vcd.New(filename, "1ns")
+	vcdtest.Check(t, e)
+	vcdtest.Check(t, WriteScope(&writer, "i2c", i2c.Tracks()))
+	writer.Close()
+	reader, e := vcd.NewReader(filename)
+	vcdtest.Check(t, e)
+	defer reader.Close()
+	values := reader.ReadAll()
+	if bytes := values["i2c.bytes"]; len(bytes) == 0 || bytes[0].Value != "W 50 ACK" || values["i2c.transactions"][0].Value != "S W 50: 12" {
+		t.Fatalf("unexpected scope %v", values)
+	}
+}
+
+// Level and duration of a bit on a CAN bus
+type canBit struct {
+	level    bool
+	duration uint64
+}
+
+// Encodes a frame with stuff bits and CRC, the data phase of CAN FD frames uses dataBitTime
+func encodeCan(id uint32, extended bool, fd bool, data []byte, dlc uint64, bitTime uint64, dataBitTime uint64) []canBit {
+	var bits []bool
+	add := func(value uint64, n int) {
+		for i := n - 1; i >= 0; i-- {
+			bits = append(bits, value>>uint(i)&1 == 1)
+		}
+	}
+	add(0, 1)
+	if extended {
+		add(uint64(id>>18), 11)
+		add(3, 2)
+		add(uint64(id&(1<<18-1)), 18)
+		add(0, 1)
+	} else {
+		add(uint64(id), 11)
+		add(0, 2)
+	}
+	if fd {
+		add(1, 1)
+		add(0, 1)
+		add(1, 1)
+		add(0, 1)
+	} else {
+		add(0, 1)
+		if extended {
+			add(0, 1)
+		}
+	}
+	add(dlc, 4)
+	for _, b := range data {
+		add(uint64(b), 8)
+	}
+	// Dynamic stuffing up to the end of the data field for CAN FD frames and the end of the CRC for classic frames
+	// The bit rate switches at the sample point of the BRS bit, which follows the bits up to res
+	brs, brsIndex := -1, 16
+	if extended {
+		brsIndex = 35
+	}
+	stuff := func(bits []bool) ([]bool, int) {
+		var stuffed []bool
+		count := 0
+		for i, bit := range bits {
+			if i == brsIndex {
+				brs = len(stuffed)
+			}
+			stuffed = append(stuffed, bit)
+			if i >= 4 && len(stuffed) >= 5 {
+				equal := true
+				for _, previous := range stuffed[len(stuffed)-5:] {
+					equal = equal && previous == bit
+				}
+				if equal {
+					stuffed = append(stuffed, !bit)
+					count++
+				}
+			}
+		}
+		return stuffed, count
+	}
+	var line []bool
+	if fd {
+		stuffed, count := stuff(bits)
+		gray := uint64(count%8) ^ uint64(count%8)>>1
+		field := []bool{gray&4 != 0, gray&2 != 0, gray&1 != 0, (gray^gray>>1^gray>>2)&1 == 1}
+		width, poly := uint(17), uint32(0x3685B)
+		if len(data) > 16 {
+			width, poly = 21, 0x302899
+		}
+		crc := canCrc(append(append([]bool(nil), stuffed...), field...), width, poly, 1<<(width-1))
+		for i := int(width) - 1; i >= 0; i-- {
+			field = append(field, crc>>uint(i)&1 == 1)
+		}
+		line = stuffed
+		for i, bit := range field {
+			if i%4 == 0 {
+				line = append(line, !line[len(line)-1])
+			}
+			line = append(line, bit)
+		}
+	} else {
+		crc := canCrc(bits, 15, 0x4599, 0)
+		add(uint64(crc), 15)
+		line, _ = stuff(bits)
+	}
+	var encoded []canBit
+	phase := bitTime
+	for i, bit := range line {
+		encoded = append(encoded, canBit{bit, phase})
+		if fd && i == brs {
+			encoded[i].duration = bitTime/2 + dataBitTime/2
+			phase = dataBitTime
+		}
+	}
+	encoded = append(encoded, canBit{true, phase/2 + bitTime/2}, canBit{false, bitTime})
+	for i := 0; i < 11; i++ {
+		encoded = append(encoded, canBit{true, bitTime})
+	}
+	return encoded
+}
+
+// Returns the changes of the bits on signal rx, starting at time
+func canChanges(time uint64, frames ...[]canBit) []change {
+	changes := []change{{Time: 0, Signal: "rx", Value: "1"}}
+	for _, frame := range frames {
+		for _, bit := range frame {
+			value := "0"
+			if bit.level {
+				value = "1"
+			}
+			changes = append(changes, change{Time: time, Signal: "rx", Value: value})
+			time += bit.duration
+		}
+	}
+	return changes
+}
+
+func TestCan(t *testing.T) {
+	// Check values of the CRC catalogue for 123456789
+	var check []bool
+	for _, c := range []byte("123456789") {
+		for i := 7; i >= 0; i-- {
+			check = append(check, c>>uint(i)&1 == 1)
+		}
+	}
+	if canCrc(check, 15, 0x4599, 0) != 0x059E || canCrc(check, 17, 0x3685B, 0) != 0x04F03 || canCrc(check, 21, 0x302899, 0) != 0x0ED841 {
+		t.Fatal("unexpected crc check values")
+	}
+
+	classic := encodeCan(0x123, false, false, []byte{0x00, 0xFF}, 2, 100, 100)
+	extended := encodeCan(0x1ABCDE0, true, false, []byte{0x11}, 1, 100, 100)
+	corrupted := encodeCan(0x7F0, false, false, []byte{0x55}, 1, 100, 100)
+	corrupted[30].level = !corrupted[30].level
+	errorFlag := []canBit{{false, 600}, {true, 1100}}
+	can, e := NewCan(CanConfig{Signal: "top.rx"})
+	vcdtest.Check(t, e)
+	vcdtest.Check(t, Run(vcdtest.Write(t, "1ns", []string{"rx"}, canChanges(1000, classic, extended, corrupted, errorFlag, classic)), can))
+	frames := can.Frames
+	if can.BitTime != 100 || len(frames) != 5 {
+		t.Fatalf("unexpected frames %+v with bit time %d", frames, can.BitTime)
+	}
+	if frames[0].ID != 0x123 || frames[0].Extended || len(frames[0].Data) != 2 || frames[0].Data[1] != 0xFF || !frames[0].CRCValid || !frames[0].Ack || frames[0].Error != "" {
+		t.Fatalf("unexpected classic frame %+v", frames[0])
+	}
+	if frames[0].Start != 1000 || frames[0].Fields[0].Name != "SOF" || frames[0].Fields[len(frames[0].Fields)-1].Name != "EOF" {
+		t.Fatalf("unexpected fields %+v", frames[0].Fields)
+	}
+	if frames[1].ID != 0x1ABCDE0 || !frames[1].Extended || frames[1].Data[0] != 0x11 || frames[1].Error != "" {
+		t.Fatalf("unexpected extended frame %+v", frames[1])
+	}
+	if frames[2].Error == "" || frames[3].Error != "error frame" || frames[4].Error != "" || frames[4].ID != 0x123 {
+		t.Fatalf("unexpected error frames %+v", frames[2:])
+	}
+	if annotations := can.FrameAnnotations(); annotations[0].Text != "123 [2] 00 FF" || annotations[1].Text != "01ABCDE0 [1] 11" {
+		t.Fatalf("unexpected annotations %+v", annotations)
+	}
+
+	data := make([]byte, 20)
+	for i := range data {
+		data[i] = byte(i * 13)
+	}
+	fd, e := NewCan(CanConfig{Signal: "top.rx", BitTime: 100, DataBitTime: 20, FD: true})
+	vcdtest.Check(t, e)
+	vcdtest.Check(t, Run(vcdtest.Write(t, "1ns", []string{"rx"}, canChanges(1000, encodeCan(0x42, false, true, data, 11, 100, 20), classic)), fd))
+	if len(fd.Frames) != 2 || !fd.Frames[0].FD || !fd.Frames[0].BitRateSwitch || len(fd.Frames[0].Data) != 20 || fd.Frames[0].Data[19] != byte(19*13) {
+		t.Fatalf("unexpected fd frames %+v", fd.Frames)
+	}
+	if !fd.Frames[0].CRCValid || fd.Frames[0].Error != "" || fd.Frames[1].ID != 0x123 || fd.Frames[1].Error != "" {
+		t.Fatalf("unexpected fd frames %+v", fd.Frames)
+	}
+
+	// The bit time of the arbitration phase is detected without the shorter bits of the data phase
+	auto, e := NewCan(CanConfig{Signal: "top.rx", DataBitTime: 20, FD: true})
+	vcdtest.Check(t, e)
+	vcdtest.Check(t, Run(vcdtest.Write(t, "1ns", []string{"rx"}, canChanges(1000, encodeCan(0x42, false, true, data, 11, 100, 20), classic)), auto))
+	if auto.BitTime != 100 || len(auto.Frames) != 2 || !auto.Frames[0].CRCValid || auto.Frames[1].ID != 0x123 || auto.Frames[1].Error != "" {
+		t.Fatalf("unexpected frames %+v with bit time %d", auto.Frames, auto.BitTime)
+	}
+}
+
+// Returns changes of a clock with a period of 10, the other signals change 5 before every rising edge
+func clockedChanges(clock string, signals []string, cycles [][]uint64) []change {
+	changes := []change{{Time: 0, Signal: clock, Value: "0"}}
+	time := uint64(10)
+	for _, cycle := range cycles {
+		for i, signal := range signals {
+			changes = append(changes, change{Time: time, Signal: signal, Value: string(rune('0' + cycle[i]))})
+		}
+		changes = append(changes, change{Time: time + 5, Signal: clock, Value: "1"}, change{Time: time + 10, Signal: clock, Value: "0"})
+		time += 10
+	}
+	return changes
+}
+
+func TestJtag(t *testing.T) {
+	var cycles [][]uint64
+	tms := func(levels ...uint64) {
+		for _, level := range levels {
+			cycles = append(cycles, []uint64{level, 0, 0})
+		}
+	}
+	shift := func(tdi uint64, tdo uint64, n int) {
+		for bit := 0; bit < n; bit++ {
+			exit := uint64(0)
+			if bit == n-1 {
+				exit = 1
+			}
+			cycles = append(cycles, []uint64{exit, tdi >> bit & 1, tdo >> bit & 1})
+		}
+	}
+	tms(1, 1, 1, 1, 1, 0, 1, 1, 0, 0)
+	shift(0xE, 0x1, 4)
+	tms(1, 0, 1, 0, 0)
+	shift(0, 0x4BA00477, 32)
+	tms(1, 0)
+	jtag, e := NewJtag(JtagConfig{Tck: "top.tck", Tms: "top.tms", Tdi: "top.tdi", Tdo: "top.tdo"})
+	vcdtest.Check(t, e)
+	vcdtest.Check(t, Run(vcdtest.Write(t, "1ns", []string{"tck", "tms", "tdi", "tdo"}, clockedChanges("tck", []string{"tms", "tdi", "tdo"}, cycles)), jtag))
+
+	if jtag.State() != RunTestIdle || len(jtag.Shifts) != 2 {
+		t.Fatalf("unexpected state %s and shifts %+v", jtag.State(), jtag.Shifts)
+	}
+	annotations := jtag.Annotations()
+	if annotations[0].Text != "IR E/1" || annotations[1].Text != "DR 00000000/4BA00477" || jtag.Shifts[1].Bits != 32 {
+		t.Fatalf("unexpected shifts %+v", annotations)
+	}
+	if jtag.Transitions[0].From != TestLogicReset || jtag.Transitions[0].To != RunTestIdle || jtag.Transitions[4].To != ShiftIR {
+		t.Fatalf("unexpected transitions %+v", jtag.Transitions)
+	}
+	if states := jtag.StateAnnotations(); states[len(states)-1].Text != "Run-Test/Idle" {
+		t.Fatalf("unexpected states %+v", states)
+	}
+}
+
+func TestSwd(t *testing.T) {
+	var cycles [][]uint64
+	bits := func(value uint64, n int) {
+		for bit := 0; bit < n; bit++ {
+			cycles = append(cycles, []uint64{value >> bit & 1})
+		}
+	}
+	bits(1<<50-1, 50)
+	bits(0, 2)
+	// Read of the DP IDCODE register
+	bits(0xA5, 8)
+	bits(0, 1)
+	bits(1, 3)
+	bits(0x2BA01477, 32)
+	bits(0, 1)
+	bits(0, 1)
+	bits(0, 2)
+	// Write of AP register 4 that has to wait
+	bits(0x8B, 8)
+	bits(0, 1)
+	bits(2, 3)
+	bits(0, 3)
+	swd, e := NewSwd(SwdConfig{Clock: "top.swclk", Data: "top.swdio"})
+	vcdtest.Check(t, e)
+	vcdtest.Check(t, Run(vcdtest.Write(t, "1ns", []string{"swclk", "swdio"}, clockedChanges("swclk", []string{"swdio"}, cycles)), swd))
+
+	if len(swd.LineResets) != 1 || len(swd.Packets) != 2 {
+		t.Fatalf("unexpected packets %+v and line resets %v", swd.Packets, swd.LineResets)
+	}
+	read, write := swd.Packets[0], swd.Packets[1]
+	if read.AP || !read.Read || read.Ack != SwdOk || read.Data != 0x2BA01477 || read.ParityError {
+		t.Fatalf("unexpected read %+v", read)
+	}
+	if !write.AP || write.Read || write.Address != 4 || write.Ack != SwdWait {
+		t.Fatalf("unexpected write %+v", write)
+	}
+	if annotations := swd.Annotations(); annotations[1].Text != "DP R 0 OK 2BA01477" || annotations[2].Text != "AP W 4 WAIT" {
+		t.Fatalf("unexpected annotations %+v", annotations)
+	}
+}
+
+func TestSession(t *testing.T) {
+	bus := &i2cBus{changes: []change{{Time: 0, Signal: "scl", Value: "1"}, {Time: 0, Signal: "sda", Value: "1"}}, time: 10}
+	bus.start()
+	bus.byte(0x50<<1, true)
+	bus.byte(0x10, true)
+	bus.byte(0xAB, true)
+	bus.byte(0xCD, true)
+	bus.stop()
+	bus.start()
+	bus.byte(0x50<<1, true)
+	bus.byte(0x10, true)
+	bus.start()
+	bus.byte(0x50<<1|1, true)
+	bus.byte(0xAB, true)
+	bus.byte(0xCD, false)
+	bus.stop()
+
+	if _, e := NewDecoder("i2c", []string{"top.scl"}, nil); e == nil {
+		t.Fatal("expected an error for a missing input")
+	}
+	if _, e := NewDecoder("eeprom", nil, map[string]string{"size": "2"}); e == nil {
+		t.Fatal("expected an error for an unknown option")
+	}
+	i2c, e := NewDecoder("i2c", []string{"top.scl", "top.sda"}, nil)
+	vcdtest.Check(t, e)
+	eeprom, e := NewDecoder("eeprom", nil, map[string]string{"address": "0x50"})
+	vcdtest.Check(t, e)
+	session := NewSession()
+	vcdtest.Check(t, session.Add("i2c", i2c))
+	vcdtest.Check(t, session.Stack("eeprom", "i2c", eeprom.(Stacked)))
+	if session.Stack("other", "spi", eeprom.(Stacked)) == nil {
+		t.Fatal("expected an error for an unknown source")
+	}
+	vcdtest.Check(t, session.Run(vcdtest.Write(t, "1ns", []string{"scl", "sda"}, bus.changes)))
+
+	accesses := session.Decoder("eeprom").(*Eeprom).Accesses
+	if len(accesses) != 2 || accesses[0].Read || accesses[0].Address != 0x10 || !accesses[1].Read || accesses[1].Address != 0x10 || accesses[1].Data[1] != 0xCD {
+		t.Fatalf("unexpected accesses %+v", accesses)
+	}
+	tracks := session.Tracks()
+	if len(tracks["i2c_transactions"]) != 3 || tracks["eeprom"][1].Text != "R 10: AB CD" {
+		t.Fatalf("unexpected tracks %+v", tracks)
+	}
+}
@@ -0,0 +1,385 @@
+package decode
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/elamre/vcd"
+)
+
+// Protocol decoder that is fed value changes and produces annotations
+type Decoder interface {
+	Consumer
+	Annotations() []Annotation
+}
+
+// Item decoded by a decoder for decoders stacked on it, such as an I2cTransaction
+type Event struct {
+	Start int64
+	End   int64
+	Data  interface{}
+}
+
+// Decoder which emits events for stacked decoders, available after Finish
+type Source interface {
+	Events() []Event
+}
+
+// Decoder stacked on the events of a Source instead of signals, such as Eeprom on I2c
+// Its Inputs are empty and Change is never called
+type Stacked interface {
+	Decoder
+	Event(event Event)
+}
+
+// Option of a registered decoder, values are passed as strings such as 8 or true
+type Option struct {
+	Name        string
+	Default     string
+	Description string
+}
+
+// Describes a decoder for the registry
+type DecoderInfo struct {
+	Name    string
+	Inputs  []string // Roles of the input signals such as clk and mosi, optional roles end with ?
+	Stacks  string   // Name of the decoder a stacked decoder consumes the events of
+	Options []Option
+	// Creates the decoder for the full names of the input signals, in the order of Inputs, and all options
+	New func(inputs []string, options map[string]string) (Decoder, error)
+}
+
+var decoders []DecoderInfo
+
+func init() {
+	RegisterDecoder(DecoderInfo{
+		Name:   "spi",
+		Inputs: []string{"clk", "mosi?", "miso?", "cs?"},
+		Options: []Option{
+			{"cpol", "0", "Clock level when idle"},
+			{"cpha", "0", "0 samples on the leading clock edge, 1 on the trailing edge"},
+			{"lsb_first", "false", "Words are shifted least significant bit first"},
+			{"word_size", "8", "Bits per word"},
+			{"cs_active_high", "false", "The bus is selected while cs is high"},
+			{"idle_gap", "0", "Time units without clock edges which end a transaction, required without cs"},
+		},
+		New: func(inputs []string, options map[string]string) (Decoder, error) {
+			config := SpiConfig{Clock: inputs[0], Mosi: inputs[1], Miso: inputs[2], Select: inputs[3]}
+			var err error
+			if config.Cpol, err = optionInt(options, "cpol"); err != nil {
+				return nil, err
+			}
+			if config.Cpha, err = optionInt(options, "cpha"); err != nil {
+				return nil, err
+			}
+			if config.LsbFirst, err = optionBool(options, "lsb_first"); err != nil {
+				return nil, err
+			}
+			if config.WordSize, err = optionInt(options, "word_size"); err != nil {
+				return nil, err
+			}
+			if config.SelectActiveHigh, err = optionBool(options, "cs_active_high"); err != nil {
+				return nil, err
+			}
+			idleGap, err := optionInt(options, "idle_gap")
+			if err != nil {
+				return nil, err
+			}
+			config.IdleGap = int64(idleGap)
+			return NewSpi(config)
+		},
+	})
+	RegisterDecoder(DecoderInfo{
+		Name:   "uart",
+		Inputs: []string{"rx"},
+		Options: []Option{
+			{"bit_time", "0", "Time units per bit, detected from the shortest pulse when 0"},
+			{"data_bits", "8", "Data bits per frame"},
+			{"parity", "none", "none, odd or even"},
+			{"stop_bits", "1", "Stop bits per frame"},
+			{"inverted", "false", "The line idles low"},
+		},
+		New: func(inputs []string, options map[string]string) (Decoder, error) {
+			config := UartConfig{Signal: inputs[0]}
+			bitTime, err := optionInt(options, "bit_time")
+			if err != nil {
+				return nil, err
+			}
+			config.BitTime = int64(bitTime)
+			if config.DataBits, err = optionInt(options, "data_bits"); err != nil {
+				return nil, err
+			}
+			if config.StopBits, err = optionInt(options, "stop_bits"); err != nil {
+				return nil, err
+			}
+			if config.Inverted, err = optionBool(options, "inverted"); err != nil {
+				return nil, err
+			}
+			switch options["parity"] {
+			case "none":
+			case "odd":
+				config.Parity = ParityOdd
+			case "even":
+				config.Parity = ParityEven
+			default:
+				return nil, fmt.Errorf("option parity: unknown parity %s", options["parity"])
+			}
+			return NewUart(config)
+		},
+	})
+	RegisterDecoder(DecoderInfo{
+		Name:   "i2c",
+		Inputs: []string{"scl", "sda"},
+		New: func(inputs []string, options map[string]string) (Decoder, error) {
+			return NewI2c(I2cConfig{Clock: inputs[0], Data: inputs[1]})
+		},
+	})
+	RegisterDecoder(DecoderInfo{
+		Name:   "can",
+		Inputs: []string{"rx"},
+		Options: []Option{
+			{"bit_time", "0", "Time units per bit of the arbitration phase, detected from the shortest pulse when 0"},
+			{"data_bit_time", "0", "Time units per bit of the CAN FD data phase, bit_time when 0"},
+			{"fd", "false", "Decode CAN FD frames"},
+		},
+		New: func(inputs []string, options map[string]string) (Decoder, error) {
+			config := CanConfig{Signal: inputs[0]}
+			bitTime, err := optionInt(options, "bit_time")
+			if err != nil {
+				return nil, err
+			}
+			dataBitTime, err := optionInt(options, "data_bit_time")
+			if err != nil {
+				return nil, err
+			}
+			config.BitTime, config.DataBitTime = int64(bitTime), int64(dataBitTime)
+			if config.FD, err = optionBool(options, "fd"); err != nil {
+				return nil, err
+			}
+			return NewCan(config)
+		},
+	})
+	RegisterDecoder(DecoderInfo{
+		Name:   "jtag",
+		Inputs: []string{"tck", "tms", "tdi?", "tdo?"},
+		New: func(inputs []string, options map[string]string) (Decoder, error) {
+			return NewJtag(JtagConfig{Tck: inputs[0], Tms: inputs[1], Tdi: inputs[2], Tdo: inputs[3]})
+		},
+	})
+	RegisterDecoder(DecoderInfo{
+		Name:    "swd",
+		Inputs:  []string{"swclk", "swdio"},
+		Options: []Option{{"turnaround", "1", "Clock cycles of a turnaround"}},
+		New: func(inputs []string, options map[string]string) (Decoder, error) {
+			turnaround, err := optionInt(options, "turnaround")
+			if err != nil {
+				return nil, err
+			}
+			return NewSwd(SwdConfig{Clock: inputs[0], Data: inputs[1], Turnaround: turnaround})
+		},
+	})
+	RegisterDecoder(DecoderInfo{
+		Name:   "eeprom",
+		Stacks: "i2c",
+		Options: []Option{
+			{"address", "0x50", "Bus address"},
+			{"address_bytes", "1", "Bytes of the memory address"},
+		},
+		New: func(inputs []string, options map[string]string) (Decoder, error) {
+			address, err := optionInt(options, "address")
+			if err != nil {
+				return nil, err
+			}
+			addressBytes, err := optionInt(options, "address_bytes")
+			if err != nil {
+				return nil, err
+			}
+			return NewEeprom(EepromConfig{Address: uint16(address), AddressBytes: addressBytes})
+		},
+	})
+}
+
+// Adds a decoder to the registry, replacing a registered decoder with the same name
+func RegisterDecoder(info DecoderInfo) {
+	for i, registered := range decoders {
+		if registered.Name == info.Name {
+			decoders[i] = info
+			return
+		}
+	}
+	decoders = append(decoders, info)
+}
+
+// Returns the registered decoders
+func Decoders() []DecoderInfo {
+	return append([]DecoderInfo(nil), decoders...)
+}
+
+// Returns the registered decoder with the given name
+func DecoderByName(name string) (DecoderInfo, error) {
+	for _, info := range decoders {
+		if info.Name == name {
+			return info, nil
+		}
+	}
+	return DecoderInfo{}, fmt.Errorf("unknown decoder: \"%s\"", name)
+}
+
+// Creates a registered decoder, options which are not given get their default
+// Returns an error for unknown options and missing inputs
+func NewDecoder(name string, inputs []string, options map[string]string) (Decoder, error) {
+	info, err := DecoderByName(name)
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]string)
+	for _, option := range info.Options {
+		values[option.Name] = option.Default
+	}
+	for key, value := range options {
+		if _, ok := values[key]; !ok {
+			return nil, fmt.Errorf("%s: unknown option %s", name, key)
+		}
+		values[key] = value
+	}
+	if len(inputs) > len(info.Inputs) {
+		return nil, fmt.Errorf("%s: %d inputs given, expected %s", name, len(inputs), strings.Join(info.Inputs, " "))
+	}
+	signals := make([]string, len(info.Inputs))
+	copy(signals, inputs)
+	for i, role := range info.Inputs {
+		if signals[i] == "" && !strings.HasSuffix(role, "?") {
+			return nil, fmt.Errorf("%s: missing input %s", name, role)
+		}
+	}
+	return info.New(signals, values)
+}
+
+func optionInt(options map[string]string, name string) (int, error) {
+	value, err := strconv.ParseInt(options[name], 0, 64)
+	if err != nil {
+		return 0, fmt.Errorf("option %s: %w", name, err)
+	}
+	return int(value), nil
+}
+
+func optionBool(options map[string]string, name string) (bool, error) {
+	value, err := strconv.ParseBool(options[name])
+	if err != nil {
+		return false, fmt.Errorf("option %s: %w", name, err)
+	}
+	return value, nil
+}
+
+// Decoders run over a waveform in a single pass, with stacked decoders fed afterwards
+type Session struct {
+	names    []string
+	decoders map[string]Decoder
+	sources  map[string]string // Name of the decoder every stacked decoder consumes
+}
+
+// Creates an empty session
+func NewSession() *Session {
+	return &Session{decoders: make(map[string]Decoder), sources: make(map[string]string)}
+}
+
+func (session *Session) add(name string, decoder Decoder) error {
+	if _, ok := session.decoders[name]; ok || name == "" {
+		return fmt.Errorf("decoder name %s is empty or already used", name)
+	}
+	session.names = append(session.names, name)
+	session.decoders[name] = decoder
+	return nil
+}
+
+// Adds a decoder of signals under a unique name
+func (session *Session) Add(name string, decoder Decoder) error {
+	return session.add(name, decoder)
+}
+
+// Adds a decoder stacked on the events of the decoder added as source
+func (session *Session) Stack(name string, source string, decoder Stacked) error {
+	if _, ok := session.decoders[source].(Source); !ok {
+		return fmt.Errorf("decoder %s does not exist or emits no events", source)
+	}
+	if err := session.add(name, decoder); err != nil {
+		return err
+	}
+	session.sources[name] = source
+	return nil
+}
+
+// Returns the decoder added under name, or nil
+func (session *Session) Decoder(name string) Decoder {
+	return session.decoders[name]
+}
+
+// Feeds the value changes of reader to all decoders in a single pass
+// Stacked decoders are fed the events of their source afterwards, in the order they were added
+func (session *Session) Run(reader vcd.WaveReader) error {
+	var consumers []Consumer
+	for _, name := range session.names {
+		if _, stacked := session.sources[name]; !stacked {
+			consumers = append(consumers, session.decoders[name])
+		}
+	}
+	last := int64(0)
+	consumers = append(consumers, &lastTime{time: &last})
+	if err := Run(reader, consumers...); err != nil {
+		return err
+	}
+	for _, name := range session.names {
+		source, stacked := session.sources[name]
+		if !stacked {
+			continue
+		}
+		decoder := session.decoders[name].(Stacked)
+		events := session.decoders[source].(Source).Events()
+		sort.SliceStable(events, func(i, j int) bool { return events[i].Start < events[j].Start })
+		for _, event := range events {
+			decoder.Event(event)
+		}
+		decoder.Finish(last)
+	}
+	return nil
+}
+
+// Consumer without inputs that keeps the time passed to Finish
+type lastTime struct {
+	time *int64
+}
+
+func (l *lastTime) Inputs() []string {
+	return nil
+}
+
+func (l *lastTime) Change(time int64, input int, value string) {
+}
+
+func (l *lastTime) Finish(time int64) {
+	*l.time = time
+}
+
+// Decoder with several annotation tracks
+type tracker interface {
+	Tracks() map[string][]Annotation
+}
+
+// Returns the annotations of all decoders by name, to write with WriteScope
+// Decoders with several tracks, such as I2c, get a track per name such as i2c_bytes
+func (session *Session) Tracks() map[string][]Annotation {
+	tracks := make(map[string][]Annotation)
+	for _, name := range session.names {
+		decoder := session.decoders[name]
+		if multi, ok := decoder.(tracker); ok {
+			for track, annotations := range multi.Tracks() {
+				tracks[name+"_"+track] = annotations
+			}
+		} else {
+			tracks[name] = decoder.Annotations()
+		}
+	}
+	return tracks
+}
@@ -0,0 +1,108 @@
+package decode
+
+import (
+	"fmt"
+	"strings"
+)
+
+// I2C EEPROM such as the 24C02, stacked on an I2c decoder
+type EepromConfig struct {
+	Address      uint16 // Bus address, 0x50 when 0
+	AddressBytes int    // Bytes of the memory address, 1 when 0, 2 for EEPROMs from 32 kbit on
+}
+
+// Read or write of consecutive memory bytes
+type EepromAccess struct {
+	Start   int64
+	End     int64
+	Read    bool
+	Address uint
+	Data    []byte
+}
+
+// Decoder for I2C EEPROM accesses, stacked on an I2c decoder with Session.Stack
+// A write without data only sets the address for the next read
+type Eeprom struct {
+	Accesses []EepromAccess
+
+	config  EepromConfig
+	pointer uint
+}
+
+// Creates a decoder for the EEPROM described by config
+func NewEeprom(config EepromConfig) (*Eeprom, error) {
+	if config.Address == 0 {
+		config.Address = 0x50
+	}
+	if config.AddressBytes == 0 {
+		config.AddressBytes = 1
+	}
+	if config.AddressBytes < 0 || config.AddressBytes > 4 {
+		return nil, fmt.Errorf("eeprom: invalid address size of %d bytes", config.AddressBytes)
+	}
+	return &Eeprom{config: config}, nil
+}
+
+func (eeprom *Eeprom) Inputs() []string {
+	return nil
+}
+
+func (eeprom *Eeprom) Change(time int64, input int, value string) {
+}
+
+func (eeprom *Eeprom) Event(event Event) {
+	transaction, ok := event.Data.(I2cTransaction)
+	if !ok || transaction.Address != eeprom.config.Address || !transaction.AddressAck() || transaction.TenBit {
+		return
+	}
+	var data []byte
+	for _, b := range transaction.Data {
+		data = append(data, b.Value)
+	}
+	if !transaction.Read {
+		if len(data) < eeprom.config.AddressBytes {
+			return
+		}
+		eeprom.pointer = 0
+		for _, b := range data[:eeprom.config.AddressBytes] {
+			eeprom.pointer = eeprom.pointer<<8 | uint(b)
+		}
+		data = data[eeprom.config.AddressBytes:]
+	}
+	if len(data) == 0 {
+		return
+	}
+	eeprom.Accesses = append(eeprom.Accesses, EepromAccess{Start: transaction.Start, End: transaction.End, Read: transaction.Read, Address: eeprom.pointer, Data: data})
+	eeprom.pointer += uint(len(data))
+}
+
+func (eeprom *Eeprom) Finish(time int64) {
+}
+
+// Returns an annotation for every access, such as R 0010: 12 34
+func (eeprom *Eeprom) Annotations() []Annotation {
+	var annotations []Annotation
+	digits := eeprom.config.AddressBytes * 2
+	for _, access := range eeprom.Accesses {
+		direction := "W"
+		if access.Read {
+			direction = "R"
+		}
+		bytes := make([]string, len(access.Data))
+		for i, b := range access.Data {
+			bytes[i] = fmt.Sprintf("%02X", b)
+		}
+		text := fmt.Sprintf("%s %0*X: %s", direction, digits, access.Address, strings.Join(bytes, " "))
+		annotations = append(annotations, Annotation{Start: access.Start, End: access.End, Text: text})
+	}
+	return annotations
+}
+
+// Returns every access as an event with an EepromAccess
+func (eeprom *Eeprom) Events() []Event {
+	var events []Event
+	for _, access := range eeprom.Accesses {
+		events = append(events, Event{Start: access.Start, End: access.End, Data: access})
+	}
+	return events
+}
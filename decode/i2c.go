@@ -0,0 +1,231 @@
+package decode
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Signals of an I2C bus
+type I2cConfig struct {
+	Clock string
+	Data  string
+}
+
+// Byte of an I2C transaction with the acknowledge bit that followed it
+type I2cByte struct {
+	Start int64 // Rising clock edge of the first bit
+	End   int64 // Rising clock edge of the acknowledge bit
+	Value byte
+	Ack   bool
+}
+
+// Transfer from a start or repeated start up to the next start or stop
+type I2cTransaction struct {
+	Start         int64
+	End           int64
+	RepeatedStart bool
+	Stopped       bool // Ended by a stop, not by a repeated start or the end of the waveform
+	Address       uint16
+	TenBit        bool
+	Read          bool
+	AddressBytes  []I2cByte // One byte for 7 bit addresses, two for 10 bit writes
+	Data          []I2cByte
+}
+
+// Returns true when the target acknowledged its address
+func (transaction I2cTransaction) AddressAck() bool {
+	return len(transaction.AddressBytes) > 0 && transaction.AddressBytes[len(transaction.AddressBytes)-1].Ack
+}
+
+// Decoder for I2C buses, see I2cConfig
+type I2c struct {
+	Transactions []I2cTransaction
+
+	config  I2cConfig
+	clock   bool
+	data    bool
+	open    *I2cTransaction
+	current I2cByte
+	bits    int
+	tenBit  uint16 // Address of the last 10 bit write, which a 10 bit read after a repeated start refers to
+}
+
+const (
+	i2cClock = iota
+	i2cData
+)
+
+// Creates a decoder for the bus described by config
+func NewI2c(config I2cConfig) (*I2c, error) {
+	if config.Clock == "" || config.Data == "" {
+		return nil, errors.New("i2c: a clock and a data signal are required")
+	}
+	return &I2c{config: config, clock: true, data: true}, nil
+}
+
+func (i2c *I2c) Inputs() []string {
+	return []string{i2c.config.Clock, i2c.config.Data}
+}
+
+func (i2c *I2c) Change(time int64, input int, value string) {
+	level, known := Level(value)
+	if !known {
+		return
+	}
+	switch input {
+	case i2cData:
+		if i2c.clock && level != i2c.data {
+			if level {
+				i2c.stop(time)
+			} else {
+				i2c.start(time)
+			}
+		}
+		i2c.data = level
+	case i2cClock:
+		if level && !i2c.clock && i2c.open != nil {
+			i2c.bit(time)
+		}
+		i2c.clock = level
+	}
+}
+
+func (i2c *I2c) start(time int64) {
+	repeated := i2c.open != nil
+	i2c.end(time, false)
+	i2c.open = &I2cTransaction{Start: time, RepeatedStart: repeated}
+	i2c.bits = 0
+}
+
+func (i2c *I2c) stop(time int64) {
+	i2c.end(time, true)
+}
+
+func (i2c *I2c) end(time int64, stopped bool) {
+	if i2c.open == nil {
+		return
+	}
+	i2c.open.End, i2c.open.Stopped = time, stopped
+	i2c.Transactions = append(i2c.Transactions, *i2c.open)
+	i2c.open = nil
+}
+
+// Samples a bit on the rising clock edge, 8 data bits msb first and an acknowledge bit
+func (i2c *I2c) bit(time int64) {
+	if i2c.bits == 0 {
+		i2c.current = I2cByte{Start: time}
+	}
+	if i2c.bits < 8 {
+		i2c.current.Value <<= 1
+		if i2c.data {
+			i2c.current.Value |= 1
+		}
+		i2c.bits++
+		return
+	}
+	i2c.current.End, i2c.current.Ack = time, !i2c.data
+	i2c.bits = 0
+	i2c.addByte(i2c.current)
+}
+
+func (i2c *I2c) addByte(b I2cByte) {
+	transaction := i2c.open
+	switch {
+	case len(transaction.AddressBytes) == 0:
+		transaction.AddressBytes = append(transaction.AddressBytes, b)
+		transaction.Read = b.Value&1 == 1
+		transaction.Address = uint16(b.Value >> 1)
+		// 10 bit addresses start with 11110 followed by the two high address bits
+		if b.Value&0xF8 == 0xF0 {
+			transaction.TenBit = true
+			transaction.Address = uint16(b.Value>>1&3) << 8
+			if transaction.Read && transaction.RepeatedStart && i2c.tenBit>>8 == transaction.Address>>8 {
+				transaction.Address = i2c.tenBit
+			}
+		}
+	case transaction.TenBit && !transaction.Read && len(transaction.AddressBytes) == 1:
+		transaction.AddressBytes = append(transaction.AddressBytes, b)
+		transaction.Address |= uint16(b.Value)
+		i2c.tenBit = transaction.Address
+	default:
+		transaction.Data = append(transaction.Data, b)
+	}
+}
+
+func (i2c *I2c) Finish(time int64) {
+	i2c.end(time, false)
+}
+
+func ackText(ack bool) string {
+	if ack {
+		return "ACK"
+	}
+	return "NACK"
+}
+
+// Returns the address of a transaction such as W 50, 10 bit addresses have three digits
+func (transaction I2cTransaction) addressText() string {
+	direction := "W"
+	if transaction.Read {
+		direction = "R"
+	}
+	if transaction.TenBit {
+		return fmt.Sprintf("%s %03X", direction, transaction.Address)
+	}
+	return fmt.Sprintf("%s %02X", direction, transaction.Address)
+}
+
+// Returns every transaction as an event with an I2cTransaction
+func (i2c *I2c) Events() []Event {
+	var events []Event
+	for _, transaction := range i2c.Transactions {
+		events = append(events, Event{Start: transaction.Start, End: transaction.End, Data: transaction})
+	}
+	return events
+}
+
+// Returns an annotation for the address and every data byte, such as W 50 ACK and 12 NACK
+func (i2c *I2c) Annotations() []Annotation {
+	var annotations []Annotation
+	for _, transaction := range i2c.Transactions {
+		if len(transaction.AddressBytes) > 0 {
+			annotations = append(annotations, Annotation{
+				Start: transaction.AddressBytes[0].Start,
+				End:   transaction.AddressBytes[len(transaction.AddressBytes)-1].End,
+				Text:  transaction.addressText() + " " + ackText(transaction.AddressAck()),
+			})
+		}
+		for _, b := range transaction.Data {
+			annotations = append(annotations, Annotation{Start: b.Start, End: b.End, Text: fmt.Sprintf("%02X %s", b.Value, ackText(b.Ack))})
+		}
+	}
+	return annotations
+}
+
+// Returns an annotation for every transaction, such as Sr R 50: 12 34 P
+func (i2c *I2c) TransactionAnnotations() []Annotation {
+	var annotations []Annotation
+	for _, transaction := range i2c.Transactions {
+		texts := []string{"S"}
+		if transaction.RepeatedStart {
+			texts[0] = "Sr"
+		}
+		if len(transaction.AddressBytes) > 0 {
+			texts = append(texts, transaction.addressText()+":")
+		}
+		for _, b := range transaction.Data {
+			texts = append(texts, fmt.Sprintf("%02X", b.Value))
+		}
+		if transaction.Stopped {
+			texts = append(texts, "P")
+		}
+		annotations = append(annotations, Annotation{Start: transaction.Start, End: transaction.End, Text: strings.Join(texts, " ")})
+	}
+	return annotations
+}
+
+// Returns the tracks to write with WriteScope, bytes and transactions
+func (i2c *I2c) Tracks() map[string][]Annotation {
+	return map[string][]Annotation{"bytes": i2c.Annotations(), "transactions": i2c.TransactionAnnotations()}
+}
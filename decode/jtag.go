@@ -0,0 +1,251 @@
+package decode
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// State of the JTAG TAP controller
+type TapState int
+
+const (
+	TestLogicReset TapState = iota
+	RunTestIdle
+	SelectDR
+	CaptureDR
+	ShiftDR
+	Exit1DR
+	PauseDR
+	Exit2DR
+	UpdateDR
+	SelectIR
+	CaptureIR
+	ShiftIR
+	Exit1IR
+	PauseIR
+	Exit2IR
+	UpdateIR
+)
+
+var tapStateNames = []string{"Test-Logic-Reset", "Run-Test/Idle",
+	"Select-DR-Scan", "Capture-DR", "Shift-DR", "Exit1-DR", "Pause-DR", "Exit2-DR", "Update-DR",
+	"Select-IR-Scan", "Capture-IR", "Shift-IR", "Exit1-IR", "Pause-IR", "Exit2-IR", "Update-IR"}
+
+// Next state of every state for TMS low and TMS high
+var tapTransitions = [][2]TapState{
+	TestLogicReset: {RunTestIdle, TestLogicReset},
+	RunTestIdle:    {RunTestIdle, SelectDR},
+	SelectDR:       {CaptureDR, SelectIR},
+	CaptureDR:      {ShiftDR, Exit1DR},
+	ShiftDR:        {ShiftDR, Exit1DR},
+	Exit1DR:        {PauseDR, UpdateDR},
+	PauseDR:        {PauseDR, Exit2DR},
+	Exit2DR:        {ShiftDR, UpdateDR},
+	UpdateDR:       {RunTestIdle, SelectDR},
+	SelectIR:       {CaptureIR, TestLogicReset},
+	CaptureIR:      {ShiftIR, Exit1IR},
+	ShiftIR:        {ShiftIR, Exit1IR},
+	Exit1IR:        {PauseIR, UpdateIR},
+	PauseIR:        {PauseIR, Exit2IR},
+	Exit2IR:        {ShiftIR, UpdateIR},
+	UpdateIR:       {RunTestIdle, SelectDR},
+}
+
+func (state TapState) String() string {
+	if state < 0 || int(state) >= len(tapStateNames) {
+		return fmt.Sprintf("TapState(%d)", int(state))
+	}
+	return tapStateNames[state]
+}
+
+// Returns the state the TAP controller moves to on a rising TCK edge with the given TMS level
+func (state TapState) Next(tms bool) TapState {
+	if tms {
+		return tapTransitions[state][1]
+	}
+	return tapTransitions[state][0]
+}
+
+// Signals of a JTAG port, TDI and TDO can be left empty
+type JtagConfig struct {
+	Tck string
+	Tms string
+	Tdi string
+	Tdo string
+}
+
+// Change of the TAP controller state on a rising TCK edge
+type JtagTransition struct {
+	Time int64
+	From TapState
+	To   TapState
+}
+
+// Bits shifted through the instruction or data register, from the first bit up to the update state
+// Bits are stored least significant bit first, as they are shifted, bit i is bit i%8 of byte i/8
+type JtagShift struct {
+	Start int64
+	End   int64
+	IR    bool
+	Bits  int
+	Tdi   []byte
+	Tdo   []byte
+}
+
+// Returns bits as a hex number, most significant digit first
+func hexBits(bits []byte, n int) string {
+	var b strings.Builder
+	for digit := (n+3)/4 - 1; digit >= 0; digit-- {
+		value := 0
+		for bit := digit*4 + 3; bit >= digit*4; bit-- {
+			value <<= 1
+			if bit < n && bits[bit/8]>>(bit%8)&1 == 1 {
+				value |= 1
+			}
+		}
+		fmt.Fprintf(&b, "%X", value)
+	}
+	return b.String()
+}
+
+// Decoder for the TAP controller of a JTAG port, see JtagConfig
+// The controller starts in Test-Logic-Reset, five clocks with TMS high reach it from any state
+type Jtag struct {
+	Transitions []JtagTransition
+	Shifts      []JtagShift
+
+	config JtagConfig
+	state  TapState
+	tck    bool
+	tms    bool
+	tdi    bool
+	tdo    bool
+	shift  *JtagShift
+	end    int64
+}
+
+const (
+	jtagTck = iota
+	jtagTms
+	jtagTdi
+	jtagTdo
+)
+
+// Creates a decoder for the port described by config
+func NewJtag(config JtagConfig) (*Jtag, error) {
+	if config.Tck == "" || config.Tms == "" {
+		return nil, errors.New("jtag: TCK and TMS are required")
+	}
+	return &Jtag{config: config}, nil
+}
+
+func (jtag *Jtag) Inputs() []string {
+	return []string{jtag.config.Tck, jtag.config.Tms, jtag.config.Tdi, jtag.config.Tdo}
+}
+
+func (jtag *Jtag) Change(time int64, input int, value string) {
+	level, known := Level(value)
+	if !known {
+		return
+	}
+	switch input {
+	case jtagTms:
+		jtag.tms = level
+	case jtagTdi:
+		jtag.tdi = level
+	case jtagTdo:
+		jtag.tdo = level
+	case jtagTck:
+		if level && !jtag.tck {
+			jtag.clock(time)
+		}
+		jtag.tck = level
+	}
+}
+
+// Shifts a bit in the shift states and moves to the next state on a rising TCK edge
+func (jtag *Jtag) clock(time int64) {
+	if jtag.state == ShiftDR || jtag.state == ShiftIR {
+		if jtag.shift == nil {
+			jtag.shift = &JtagShift{Start: time, IR: jtag.state == ShiftIR}
+		}
+		shift := jtag.shift
+		if shift.Bits%8 == 0 {
+			shift.Tdi = append(shift.Tdi, 0)
+			shift.Tdo = append(shift.Tdo, 0)
+		}
+		if jtag.tdi {
+			shift.Tdi[shift.Bits/8] |= 1 << (shift.Bits % 8)
+		}
+		if jtag.tdo {
+			shift.Tdo[shift.Bits/8] |= 1 << (shift.Bits % 8)
+		}
+		shift.Bits++
+		shift.End = time
+	}
+	next := jtag.state.Next(jtag.tms)
+	if next != jtag.state {
+		jtag.Transitions = append(jtag.Transitions, JtagTransition{Time: time, From: jtag.state, To: next})
+	}
+	switch next {
+	case UpdateDR, UpdateIR:
+		if jtag.shift != nil {
+			jtag.Shifts = append(jtag.Shifts, *jtag.shift)
+		}
+		jtag.shift = nil
+	case TestLogicReset:
+		jtag.shift = nil
+	}
+	jtag.state = next
+}
+
+func (jtag *Jtag) Finish(time int64) {
+	jtag.end = time
+}
+
+// Returns the state of the TAP controller after the last rising TCK edge
+func (jtag *Jtag) State() TapState {
+	return jtag.state
+}
+
+// Returns an annotation for every state, lasting until the next transition
+func (jtag *Jtag) StateAnnotations() []Annotation {
+	var annotations []Annotation
+	for i, transition := range jtag.Transitions {
+		until := jtag.end
+		if i+1 < len(jtag.Transitions) {
+			until = jtag.Transitions[i+1].Time
+		}
+		annotations = append(annotations, Annotation{Start: transition.Time, End: until, Text: transition.To.String()})
+	}
+	return annotations
+}
+
+// Returns every shift as an event with a JtagShift
+func (jtag *Jtag) Events() []Event {
+	var events []Event
+	for _, shift := range jtag.Shifts {
+		events = append(events, Event{Start: shift.Start, End: shift.End, Data: shift})
+	}
+	return events
+}
+
+// Returns an annotation for every shift, such as IR 0E/01 with the TDI and TDO bits in hex
+func (jtag *Jtag) Annotations() []Annotation {
+	var annotations []Annotation
+	for _, shift := range jtag.Shifts {
+		register := "DR"
+		if shift.IR {
+			register = "IR"
+		}
+		text := fmt.Sprintf("%s %s/%s", register, hexBits(shift.Tdi, shift.Bits), hexBits(shift.Tdo, shift.Bits))
+		annotations = append(annotations, Annotation{Start: shift.Start, End: shift.End, Text: text})
+	}
+	return annotations
+}
+
+// Returns the tracks to write with WriteScope, states and shifts
+func (jtag *Jtag) Tracks() map[string][]Annotation {
+	return map[string][]Annotation{"states": jtag.StateAnnotations(), "shifts": jtag.Annotations()}
+}
@@ -0,0 +1,208 @@
+package decode
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Signals and settings of an SPI bus
+// Mosi or Miso can be left empty for unidirectional buses
+// Without Select the bus is always selected, and transactions end when the clock is idle for longer than IdleGap
+type SpiConfig struct {
+	Clock  string
+	Mosi   string
+	Miso   string
+	Select string
+
+	Cpol             int // Clock level when idle
+	Cpha             int // 0 samples on the leading clock edge, 1 on the trailing edge
+	LsbFirst         bool
+	WordSize         int // Bits per word, 8 when 0
+	SelectActiveHigh bool
+	IdleGap          int64 // Time units without clock edges which end a transaction, required without Select
+}
+
+// Word shifted in both directions, from the first to the last sampling edge
+type SpiWord struct {
+	Start   int64
+	End     int64
+	Mosi    uint64
+	Miso    uint64
+	Bits    int  // Less than the word size when the transaction ended early
+	Unknown bool // A sampled bit was x or z, it is counted as 0
+}
+
+// Words shifted while the bus was selected
+type SpiTransaction struct {
+	Start int64
+	End   int64
+	Words []SpiWord
+}
+
+// Decoder for SPI buses, see SpiConfig
+type Spi struct {
+	Transactions []SpiTransaction
+
+	config   SpiConfig
+	clock    string // Last known clock value, empty before the first one
+	mosi     string
+	miso     string
+	selected bool
+	lastEdge int64 // Time of the last clock edge, for the idle gap
+	word     SpiWord
+	open     *SpiTransaction
+}
+
+const (
+	spiClock = iota
+	spiMosi
+	spiMiso
+	spiSelect
+)
+
+// Creates a decoder for the bus described by config
+func NewSpi(config SpiConfig) (*Spi, error) {
+	if config.Clock == "" || config.Mosi == "" && config.Miso == "" {
+		return nil, errors.New("spi: a clock and at least one data signal are required")
+	}
+	if config.Cpol&^1 != 0 || config.Cpha&^1 != 0 {
+		return nil, fmt.Errorf("spi: invalid mode cpol %d cpha %d", config.Cpol, config.Cpha)
+	}
+	if config.WordSize == 0 {
+		config.WordSize = 8
+	}
+	if config.WordSize < 0 || config.WordSize > 64 {
+		return nil, fmt.Errorf("spi: invalid word size %d", config.WordSize)
+	}
+	if config.IdleGap < 0 || config.Select == "" && config.IdleGap == 0 {
+		return nil, fmt.Errorf("spi: a select signal or an idle gap is required, got idle gap %d", config.IdleGap)
+	}
+	return &Spi{config: config, selected: config.Select == ""}, nil
+}
+
+func (spi *Spi) Inputs() []string {
+	return []string{spi.config.Clock, spi.config.Mosi, spi.config.Miso, spi.config.Select}
+}
+
+func (spi *Spi) Change(time int64, input int, value string) {
+	switch input {
+	case spiMosi:
+		spi.mosi = value
+	case spiMiso:
+		spi.miso = value
+	case spiSelect:
+		level, known := Level(value)
+		selected := known && level == spi.config.SelectActiveHigh
+		if selected && !spi.selected {
+			spi.open = &SpiTransaction{Start: time}
+			spi.word = SpiWord{}
+		} else if !selected && spi.selected {
+			spi.closeTransaction(time)
+		}
+		spi.selected = selected
+	case spiClock:
+		level, known := Level(value)
+		if !known {
+			return
+		}
+		// The first known level is the initial state, not an edge
+		previous, _ := Level(spi.clock)
+		first := spi.clock == ""
+		spi.clock = value
+		rising := level && !previous
+		falling := !level && previous
+		if first || !rising && !falling {
+			return
+		}
+		if spi.config.Select == "" && spi.open != nil && time-spi.lastEdge > spi.config.IdleGap {
+			spi.closeTransaction(spi.lastEdge)
+		}
+		spi.lastEdge = time
+		// Mode 0 and 3 sample on the rising edge, mode 1 and 2 on the falling edge
+		sampleRising := spi.config.Cpol == spi.config.Cpha
+		if spi.selected && (rising && sampleRising || falling && !sampleRising) {
+			spi.sample(time)
+		}
+	}
+}
+
+func (spi *Spi) sample(time int64) {
+	if spi.open == nil {
+		spi.open = &SpiTransaction{Start: time}
+	}
+	if spi.word.Bits == 0 {
+		spi.word.Start = time
+	}
+	spi.word.End = time
+	mosi, mosiKnown := Level(spi.mosi)
+	miso, misoKnown := Level(spi.miso)
+	if spi.config.Mosi != "" && !mosiKnown || spi.config.Miso != "" && !misoKnown {
+		spi.word.Unknown = true
+	}
+	bit := uint(spi.word.Bits)
+	if !spi.config.LsbFirst {
+		bit = uint(spi.config.WordSize - 1 - spi.word.Bits)
+	}
+	if mosi {
+		spi.word.Mosi |= 1 << bit
+	}
+	if miso {
+		spi.word.Miso |= 1 << bit
+	}
+	spi.word.Bits++
+	if spi.word.Bits == spi.config.WordSize {
+		spi.open.Words = append(spi.open.Words, spi.word)
+		spi.word = SpiWord{}
+	}
+}
+
+func (spi *Spi) closeTransaction(time int64) {
+	if spi.open == nil {
+		return
+	}
+	if spi.word.Bits > 0 {
+		// Align the bits of an incomplete word as if the rest had been shifted as 0
+		spi.open.Words = append(spi.open.Words, spi.word)
+		spi.word = SpiWord{}
+	}
+	spi.open.End = time
+	spi.Transactions = append(spi.Transactions, *spi.open)
+	spi.open = nil
+}
+
+func (spi *Spi) Finish(time int64) {
+	spi.closeTransaction(time)
+}
+
+// Returns every transaction as an event with a SpiTransaction
+func (spi *Spi) Events() []Event {
+	var events []Event
+	for _, transaction := range spi.Transactions {
+		events = append(events, Event{Start: transaction.Start, End: transaction.End, Data: transaction})
+	}
+	return events
+}
+
+// Returns an annotation for every word, such as 3C/A5 for MOSI 3C and MISO A5
+func (spi *Spi) Annotations() []Annotation {
+	var annotations []Annotation
+	digits := (spi.config.WordSize + 3) / 4
+	for _, transaction := range spi.Transactions {
+		for _, word := range transaction.Words {
+			var values []string
+			if spi.config.Mosi != "" {
+				values = append(values, fmt.Sprintf("%0*X", digits, word.Mosi))
+			}
+			if spi.config.Miso != "" {
+				values = append(values, fmt.Sprintf("%0*X", digits, word.Miso))
+			}
+			text := strings.Join(values, "/")
+			if word.Unknown {
+				text += "?"
+			}
+			annotations = append(annotations, Annotation{Start: word.Start, End: word.End, Text: text})
+		}
+	}
+	return annotations
+}
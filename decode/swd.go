@@ -0,0 +1,204 @@
+package decode
+
+import (
+	"errors"
+	"fmt"
+	"math/bits"
+)
+
+// Signals of a Serial Wire Debug port
+type SwdConfig struct {
+	Clock      string
+	Data       string
+	Turnaround int // Clock cycles of a turnaround, 1 when 0
+}
+
+// Acknowledge of a SWD target
+type SwdAck int
+
+const (
+	SwdOk    SwdAck = 1
+	SwdWait  SwdAck = 2
+	SwdFault SwdAck = 4
+)
+
+func (ack SwdAck) String() string {
+	switch ack {
+	case SwdOk:
+		return "OK"
+	case SwdWait:
+		return "WAIT"
+	case SwdFault:
+		return "FAULT"
+	}
+	return "NO ACK"
+}
+
+// Packet of a SWD transfer, from the start bit of the request to the last data bit
+type SwdPacket struct {
+	Start       int64
+	End         int64
+	AP          bool // Access port instead of debug port
+	Read        bool
+	Address     uint8 // Register address 0, 4, 8 or C
+	Ack         SwdAck
+	Data        uint32 // Only valid for transfers acknowledged with OK
+	ParityError bool   // Parity of the data is wrong
+}
+
+// Decoder for Serial Wire Debug ports, see SwdConfig
+// SWDIO is sampled on rising SWCLK edges, packets are decoded by Finish
+type Swd struct {
+	Packets    []SwdPacket
+	LineResets []int64 // Times at which 50 or more clocks with SWDIO high started
+	resetEnds  []int64
+
+	config  SwdConfig
+	clock   bool
+	data    bool
+	times   []int64
+	samples []bool
+}
+
+const (
+	swdClock = iota
+	swdData
+)
+
+// Creates a decoder for the port described by config
+func NewSwd(config SwdConfig) (*Swd, error) {
+	if config.Clock == "" || config.Data == "" {
+		return nil, errors.New("swd: a clock and a data signal are required")
+	}
+	if config.Turnaround == 0 {
+		config.Turnaround = 1
+	}
+	if config.Turnaround < 1 || config.Turnaround > 4 {
+		return nil, fmt.Errorf("swd: invalid turnaround of %d cycles", config.Turnaround)
+	}
+	return &Swd{config: config}, nil
+}
+
+func (swd *Swd) Inputs() []string {
+	return []string{swd.config.Clock, swd.config.Data}
+}
+
+func (swd *Swd) Change(time int64, input int, value string) {
+	level, known := Level(value)
+	switch input {
+	case swdData:
+		swd.data = level && known
+	case swdClock:
+		if known && level && !swd.clock {
+			swd.times = append(swd.times, time)
+			swd.samples = append(swd.samples, swd.data)
+		}
+		if known {
+			swd.clock = level
+		}
+	}
+}
+
+// Returns the samples from index as a number, least significant bit first
+func (swd *Swd) value(index int, n int) uint32 {
+	value := uint32(0)
+	for i := n - 1; i >= 0; i-- {
+		value <<= 1
+		if swd.samples[index+i] {
+			value |= 1
+		}
+	}
+	return value
+}
+
+// Returns true when a valid request starts at index: start, APnDP, RnW, A[2:3], parity, stop and park
+func (swd *Swd) request(index int) bool {
+	if index+8 > len(swd.samples) {
+		return false
+	}
+	request := swd.value(index, 8)
+	return request&1 == 1 && request>>6&1 == 0 && request>>7&1 == 1 && bits.OnesCount32(request>>1&0xF)%2 == int(request>>5&1)
+}
+
+func (swd *Swd) Finish(time int64) {
+	turnaround := swd.config.Turnaround
+	for i := 0; i < len(swd.samples); {
+		if swd.samples[i] {
+			ones := 0
+			for i+ones < len(swd.samples) && swd.samples[i+ones] {
+				ones++
+			}
+			if ones >= 50 {
+				swd.LineResets = append(swd.LineResets, swd.times[i])
+				swd.resetEnds = append(swd.resetEnds, swd.times[i+ones-1])
+				i += ones
+				continue
+			}
+		}
+		if !swd.request(i) {
+			i++
+			continue
+		}
+		request := swd.value(i, 8)
+		packet := SwdPacket{Start: swd.times[i], AP: request>>1&1 == 1, Read: request>>2&1 == 1, Address: uint8(request>>3&3) << 2}
+		ack := i + 8 + turnaround
+		if ack+3 > len(swd.samples) {
+			break
+		}
+		packet.Ack = SwdAck(swd.value(ack, 3))
+		end := ack + 3
+		if packet.Ack == SwdOk {
+			data := end
+			if !packet.Read {
+				data += turnaround
+			}
+			if data+33 > len(swd.samples) {
+				break
+			}
+			packet.Data = swd.value(data, 32)
+			packet.ParityError = bits.OnesCount32(packet.Data)%2 != int(swd.value(data+32, 1))
+			end = data + 33
+		}
+		packet.End = swd.times[end-1]
+		swd.Packets = append(swd.Packets, packet)
+		i = end
+		if packet.Read || packet.Ack != SwdOk {
+			i += turnaround
+		}
+	}
+}
+
+// Returns every packet as an event with a SwdPacket
+func (swd *Swd) Events() []Event {
+	var events []Event
+	for _, packet := range swd.Packets {
+		events = append(events, Event{Start: packet.Start, End: packet.End, Data: packet})
+	}
+	return events
+}
+
+// Returns an annotation for every packet such as DP R 0 OK 2BA01477, and for every line reset
+func (swd *Swd) Annotations() []Annotation {
+	var annotations []Annotation
+	for i, reset := range swd.LineResets {
+		annotations = append(annotations, Annotation{Start: reset, End: swd.resetEnds[i], Text: "line reset"})
+	}
+	for _, packet := range swd.Packets {
+		port, direction := "DP", "W"
+		if packet.AP {
+			port = "AP"
+		}
+		if packet.Read {
+			direction = "R"
+		}
+		text := fmt.Sprintf("%s %s %X %s", port, direction, packet.Address, packet.Ack)
+		if packet.Ack == SwdOk {
+			text += fmt.Sprintf(" %08X", packet.Data)
+		}
+		if packet.ParityError {
+			text += " parity error"
+		}
+		annotations = append(annotations, Annotation{Start: packet.Start, End: packet.End, Text: text})
+	}
+	return annotations
+}
@@ -0,0 +1,206 @@
+package decode
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/elamre/vcd"
+)
+
+// Parity bit of a UART frame
+type Parity int
+
+const (
+	ParityNone Parity = iota
+	ParityOdd
+	ParityEven
+)
+
+// Signal and frame format of a UART line
+type UartConfig struct {
+	Signal   string
+	BitTime  int64 // Time units per bit, see BitTime, detected from the shortest pulse when 0
+	DataBits int   // 8 when 0
+	Parity   Parity
+	StopBits int  // 1 when 0
+	Inverted bool // The line idles low instead of high
+}
+
+// Frame received on a UART line, from the start of the start bit to the end of the stop bits
+type UartFrame struct {
+	Start        int64
+	End          int64
+	Data         uint64
+	ParityError  bool
+	FramingError bool // A stop bit was not at the idle level
+}
+
+// Decoder for UART lines, see UartConfig
+// Frames are decoded by Finish, as detecting the bit time needs all edges of the line
+type Uart struct {
+	Frames  []UartFrame
+	BitTime int64 // Configured or detected time units per bit
+
+	config UartConfig
+	times  []int64
+	levels []bool
+}
+
+// Returns the time units per bit for a baud rate, such as BitTime(115200, "1ns")
+func BitTime(baud float64, timeScale string) (int64, error) {
+	seconds, err := vcd.TimescaleSeconds(timeScale)
+	if err != nil {
+		return 0, err
+	}
+	if baud <= 0 {
+		return 0, fmt.Errorf("invalid baud rate %f", baud)
+	}
+	bitTime := int64(math.Round(1 / (baud * seconds)))
+	if bitTime < 2 {
+		return 0, fmt.Errorf("baud rate %f is too high for timescale %s", baud, timeScale)
+	}
+	return bitTime, nil
+}
+
+// Creates a decoder for the line described by config
+func NewUart(config UartConfig) (*Uart, error) {
+	if config.Signal == "" {
+		return nil, errors.New("uart: a signal is required")
+	}
+	if config.DataBits == 0 {
+		config.DataBits = 8
+	}
+	if config.StopBits == 0 {
+		config.StopBits = 1
+	}
+	if config.DataBits < 0 || config.DataBits > 64 || config.StopBits < 0 || config.BitTime < 0 {
+		return nil, fmt.Errorf("uart: invalid frame format %d data bits %d stop bits", config.DataBits, config.StopBits)
+	}
+	return &Uart{config: config, BitTime: config.BitTime}, nil
+}
+
+func (uart *Uart) Inputs() []string {
+	return []string{uart.config.Signal}
+}
+
+func (uart *Uart) Change(time int64, input int, value string) {
+	level, known := Level(value)
+	if !known {
+		return
+	}
+	idle := level != uart.config.Inverted
+	if len(uart.levels) > 0 && uart.levels[len(uart.levels)-1] == idle {
+		return
+	}
+	uart.times = append(uart.times, time)
+	uart.levels = append(uart.levels, idle)
+}
+
+// Returns true when the line is at the idle level at time
+func (uart *Uart) idle(time int64) bool {
+	i := sort.Search(len(uart.times), func(i int) bool { return uart.times[i] > time })
+	return i == 0 || uart.levels[i-1]
+}
+
+// Returns the shortest time between two edges, the first change only sets the initial level
+func (uart *Uart) shortestPulse() int64 {
+	shortest := int64(0)
+	for i := 2; i < len(uart.times); i++ {
+		if pulse := uart.times[i] - uart.times[i-1]; shortest == 0 || pulse < shortest {
+			shortest = pulse
+		}
+	}
+	return shortest
+}
+
+func (uart *Uart) Finish(time int64) {
+	if uart.BitTime == 0 {
+		uart.BitTime = uart.shortestPulse()
+	}
+	if uart.BitTime == 0 {
+		return
+	}
+	bitTime := float64(uart.BitTime)
+	parityBits := 0
+	if uart.config.Parity != ParityNone {
+		parityBits = 1
+	}
+	frameBits := 1 + uart.config.DataBits + parityBits + uart.config.StopBits
+	// Returns the time of the middle of bit of a frame starting at start
+	sample := func(start int64, bit int) int64 {
+		return start + int64(math.Round((float64(bit)+0.5)*bitTime))
+	}
+
+	after := int64(math.MinInt64)
+	for i := 1; i < len(uart.times); i++ {
+		start := uart.times[i]
+		if uart.levels[i] || start < after {
+			continue
+		}
+		if uart.idle(sample(start, 0)) {
+			// Glitch shorter than half a bit
+			continue
+		}
+		if sample(start, frameBits-1) > time {
+			break
+		}
+		frame := UartFrame{Start: start, End: start + int64(math.Round(float64(frameBits)*bitTime))}
+		ones := 0
+		for bit := 0; bit < uart.config.DataBits; bit++ {
+			if uart.idle(sample(start, 1+bit)) {
+				frame.Data |= 1 << uint(bit)
+				ones++
+			}
+		}
+		if uart.config.Parity != ParityNone {
+			if uart.idle(sample(start, 1+uart.config.DataBits)) {
+				ones++
+			}
+			frame.ParityError = (ones%2 == 0) == (uart.config.Parity == ParityOdd)
+		}
+		for bit := frameBits - uart.config.StopBits; bit < frameBits; bit++ {
+			if !uart.idle(sample(start, bit)) {
+				frame.FramingError = true
+			}
+		}
+		uart.Frames = append(uart.Frames, frame)
+		after = sample(start, frameBits-1)
+	}
+}
+
+// Returns the baud rate of the bit time for the timescale of the waveform, such as 1ns
+func (uart *Uart) Baud(timeScale string) (float64, error) {
+	seconds, err := vcd.TimescaleSeconds(timeScale)
+	if err != nil || uart.BitTime == 0 {
+		return 0, err
+	}
+	return 1 / (float64(uart.BitTime) * seconds), nil
+}
+
+// Returns every frame as an event with a UartFrame
+func (uart *Uart) Events() []Event {
+	var events []Event
+	for _, frame := range uart.Frames {
+		events = append(events, Event{Start: frame.Start, End: frame.End, Data: frame})
+	}
+	return events
+}
+
+// Returns an annotation for every frame, such as 41 or 41 parity error
+func (uart *Uart) Annotations() []Annotation {
+	var annotations []Annotation
+	digits := (uart.config.DataBits + 3) / 4
+	for _, frame := range uart.Frames {
+		text := fmt.Sprintf("%0*X", digits, frame.Data)
+		if frame.ParityError {
+			text += " parity error"
+		}
+		if frame.FramingError {
+			text += " framing error"
+		}
+		annotations = append(annotations, Annotation{Start: frame.Start, End: frame.End, Text: text})
+	}
+	return annotations
+}
@@ -0,0 +1,270 @@
+// Package derive computes signals from expressions over the signals of a waveform
+//
+// Expressions use Verilog operators with 4-state semantics, where z is treated as x:
+//
+//	valid && ready
+//	addr[15:8]
+//	{hi, lo}
+//	real(adc) * 3.3 / 4096
+//	threshold(vin, 0.8, 2.0)
+//
+// Signals are referred to by their full names, such as top.cpu.valid
+// Vectors are unsigned, arithmetic keeps the width of the widest operand and is x when an operand bit is x
+// real() converts a vector to a real, threshold() converts a real to a bit with hysteresis
+package derive
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/elamre/vcd"
+)
+
+// Parsed expression
+type Expression struct {
+	text      string
+	root      node
+	signals   []string
+	variables map[string]vcd.VcdDataType
+}
+
+// Parses an expression over variables, such as returned by VcdReader.Variables
+func Parse(text string, variables []vcd.VcdDataType) (*Expression, error) {
+	tokens, err := tokenize(text)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", text, err)
+	}
+	p := &parser{tokens: tokens, variables: make(map[string]vcd.VcdDataType)}
+	for _, variable := range variables {
+		p.variables[variable.VariableName] = variable
+	}
+	root, err := p.expression()
+	if err == nil && p.peek().kind != tokenEnd {
+		err = fmt.Errorf("unexpected %s", p.peek())
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", text, err)
+	}
+	return &Expression{text: text, root: root, signals: p.signals, variables: p.variables}, nil
+}
+
+func (expr *Expression) String() string {
+	return expr.text
+}
+
+// Returns the names of the signals the expression refers to
+func (expr *Expression) Signals() []string {
+	return append([]string(nil), expr.signals...)
+}
+
+// Returns whether the expression is a real, otherwise it is a vector of Width bits
+func (expr *Expression) Real() bool {
+	return expr.root.isReal()
+}
+
+func (expr *Expression) Width() int {
+	return expr.root.width()
+}
+
+// Returns a variable which can hold the values of the expression
+func (expr *Expression) Variable(name string) vcd.VcdDataType {
+	if expr.Real() {
+		return vcd.NewVariable(name, "real", 1)
+	}
+	return vcd.NewVariable(name, "wire", expr.Width())
+}
+
+// Converts a value as returned by a reader to the declared type of its variable
+// Vectors written shorter than their width are extended as in VCD, with x or z when the first bit is x or z
+func sampleValue(sample interface{}, variable vcd.VcdDataType) value {
+	var text string
+	switch v := sample.(type) {
+	case float64:
+		return realValue(v)
+	case vcd.EvcdPortValue:
+		text = v.Value()
+	default:
+		text = strings.ToLower(fmt.Sprint(v))
+	}
+	if variable.VariableType == "real" {
+		real, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return realValue(math.NaN())
+		}
+		return realValue(real)
+	}
+	width := max(variable.BitDepth, 1)
+	if text == "" {
+		return unknown(width)
+	}
+	if len(text) < width {
+		fill := "0"
+		if text[0] == 'x' || text[0] == 'z' {
+			fill = text[:1]
+		}
+		text = strings.Repeat(fill, width-len(text)) + text
+	}
+	return value{bits: text}
+}
+
+// Returns a value as returned by a reader: a float64 for reals, a string of bits for vectors
+func (v value) sample() interface{} {
+	if v.isReal {
+		return v.real
+	}
+	return v.bits
+}
+
+// Returns whether two samples are equal, reals which are both NaN are equal
+func sameSample(a interface{}, b interface{}) bool {
+	x, okX := a.(float64)
+	y, okY := b.(float64)
+	if okX && okY && math.IsNaN(x) && math.IsNaN(y) {
+		return true
+	}
+	return a == b
+}
+
+// Returns the values of the expression over time from the values of its signals, such as returned by ReadAll
+// The expression is evaluated after all changes at a time, and a value is only returned when it changes
+// Signals without values are x
+func (expr *Expression) Evaluate(values map[string][]vcd.ReadValue) []vcd.ReadValue {
+	evaluation := &evaluation{values: make(map[string]value), thresholds: make(map[*thresholdNode]byte)}
+	for _, name := range expr.signals {
+		if expr.variables[name].VariableType == "real" {
+			evaluation.values[name] = realValue(math.NaN())
+		} else {
+			evaluation.values[name] = unknown(max(expr.variables[name].BitDepth, 1))
+		}
+	}
+	indices := make([]int, len(expr.signals))
+	var result []vcd.ReadValue
+	for {
+		time, found := int64(0), false
+		for i, name := range expr.signals {
+			if indices[i] < len(values[name]) && (!found || values[name][indices[i]].Time < time) {
+				time, found = values[name][indices[i]].Time, true
+			}
+		}
+		// Expressions without signals have a single value at 0
+		if !found && (len(expr.signals) > 0 || len(result) > 0) {
+			return result
+		}
+		for i, name := range expr.signals {
+			for indices[i] < len(values[name]) && values[name][indices[i]].Time == time {
+				evaluation.values[name] = sampleValue(values[name][indices[i]].Value, expr.variables[name])
+				indices[i]++
+			}
+		}
+		sample := convert(expr.root.eval(evaluation), expr.Width(), expr.Real()).sample()
+		if len(result) == 0 || !sameSample(result[len(result)-1].Value, sample) {
+			result = append(result, vcd.ReadValue{Time: time, Value: sample})
+		}
+	}
+}
+
+// Variables and values of a waveform in memory, extended with derived signals
+type Waveform struct {
+	Variables map[string]vcd.VcdDataType // By full name
+	Values    map[string][]vcd.ReadValue // As returned by ReadAll
+	Derived   []string                   // Names of the derived signals, in the order they were added
+}
+
+// Reads all values of a waveform into memory
+// The header is parsed first when that has not happened yet
+func Load(reader vcd.WaveReader) *Waveform {
+	if len(reader.GetIdentifiers()) == 0 {
+		reader.ParseHeader()
+	}
+	waveform := &Waveform{Variables: make(map[string]vcd.VcdDataType), Values: reader.ReadAll()}
+	for _, variable := range reader.GetIdentifiers() {
+		waveform.Variables[variable.VariableName] = variable
+	}
+	return waveform
+}
+
+// Adds a signal computed from an expression over the signals of the waveform, including earlier derived signals
+func (waveform *Waveform) Derive(name string, text string) (*Expression, error) {
+	if _, ok := waveform.Variables[name]; ok {
+		return nil, fmt.Errorf("signal %s already exists", name)
+	}
+	var variables []vcd.VcdDataType
+	for _, variable := range waveform.Variables {
+		variables = append(variables, variable)
+	}
+	expr, err := Parse(text, variables)
+	if err != nil {
+		return nil, err
+	}
+	waveform.Variables[name] = expr.Variable(name)
+	waveform.Values[name] = expr.Evaluate(waveform.Values)
+	waveform.Derived = append(waveform.Derived, name)
+	return expr, nil
+}
+
+// Returns the value of a signal at a time, false before its first value
+func (waveform *Waveform) At(name string, time int64) (interface{}, bool) {
+	values := waveform.Values[name]
+	i := sort.Search(len(values), func(i int) bool { return values[i].Time > time })
+	if i == 0 {
+		return nil, false
+	}
+	return values[i-1].Value, true
+}
+
+// Writes signals to module, by default the derived signals
+// Signals are named after the last part of their full name, vectors are written as wires
+// VCD has no unknown reals, so a real signal keeps its last value while it is NaN
+func (waveform *Waveform) Write(writer vcd.WaveWriter, module string, names ...string) error {
+	if len(names) == 0 {
+		names = waveform.Derived
+	}
+	type change struct {
+		time  int64
+		value string
+		name  string
+	}
+	var variables []vcd.VcdDataType
+	var changes []change
+	written := make(map[string]bool)
+	for _, name := range names {
+		variable, ok := waveform.Variables[name]
+		if !ok {
+			return fmt.Errorf("unknown signal %s", name)
+		}
+		short := name[strings.LastIndex(name, ".")+1:]
+		if written[short] {
+			return fmt.Errorf("signal %s is written twice", short)
+		}
+		written[short] = true
+		if variable.VariableType == "real" {
+			variables = append(variables, vcd.NewVariable(short, "real", 1))
+		} else {
+			variables = append(variables, vcd.NewVariable(short, "wire", max(variable.BitDepth, 1)))
+		}
+		for _, v := range waveform.Values[name] {
+			sample := sampleValue(v.Value, variable)
+			text := "b" + sample.bits
+			if sample.isReal {
+				if math.IsNaN(sample.real) {
+					continue
+				}
+				text = strconv.FormatFloat(sample.real, 'g', -1, 64)
+			}
+			changes = append(changes, change{v.Time, text, short})
+		}
+	}
+	if _, err := writer.RegisterVariables(module, variables...); err != nil {
+		return err
+	}
+	sort.SliceStable(changes, func(i, j int) bool { return changes[i].time < changes[j].time })
+	for _, c := range changes {
+		if err := writer.SetValue(uint64(c.time), c.value, c.name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+package derive
+
+import (
+	"math"
+	"path/filepath"
+	"testing"
+
+	"github.com/elamre/vcd"
+	"github.com/elamre/vcd/internal/vcdtest"
+)
+
+var variables = []vcd.VcdDataType{
+	vcd.NewVariable("top.valid", "wire", 1),
+	vcd.NewVariable("top.ready", "wire", 1),
+	vcd.NewVariable("top.addr", "wire", 16),
+	vcd.NewVariable("top.hi", "wire", 4),
+	vcd.NewVariable("top.lo", "wire", 4),
+	vcd.NewVariable("top.adc", "wire", 12),
+	vcd.NewVariable("top.vin", "real", 1),
+	vcd.NewVariable("top.name", "string", 1),
+}
+
+// Returns the values of an expression at the times of values
+func evaluate(t *testing.T, text string, values map[string][]vcd.ReadValue) []vcd.ReadValue {
+	expr, e := Parse(text, variables)
+	vcdtest.Check(t, e)
+	return expr.Evaluate(values)
+}
+
+func expectValues(t *testing.T, text string, actual []vcd.ReadValue, expected ...vcd.ReadValue) {
+	if len(actual) != len(expected) {
+		t.Fatalf("%s: unexpected values %v, expected %v", text, actual, expected)
+	}
+	for i := range expected {
+		if actual[i] != expected[i] {
+			t.Fatalf("%s: unexpected values %v, expected %v", text, actual, expected)
+		}
+	}
+}
+
+func TestLogic(t *testing.T) {
+	values := map[string][]vcd.ReadValue{
+		"top.valid": {{Time: 0, Value: "0"}, {Time: 10, Value: "1"}, {Time: 30, Value: "1"}},
+		"top.ready": {{Time: 0, Value: "x"}, {Time: 20, Value: "1"}, {Time: 30, Value: "z"}},
+	}
+	expectValues(t, "and", evaluate(t, "top.valid && top.ready", values),
+		vcd.ReadValue{Time: 0, Value: "0"}, vcd.ReadValue{Time: 10, Value: "x"}, vcd.ReadValue{Time: 20, Value: "1"}, vcd.ReadValue{Time: 30, Value: "x"})
+	expectValues(t, "or", evaluate(t, "top.valid || top.ready", values),
+		vcd.ReadValue{Time: 0, Value: "x"}, vcd.ReadValue{Time: 10, Value: "1"})
+	expectValues(t, "not", evaluate(t, "!top.ready ^ ~top.valid", values),
+		vcd.ReadValue{Time: 0, Value: "x"}, vcd.ReadValue{Time: 20, Value: "0"}, vcd.ReadValue{Time: 30, Value: "x"})
+	expectValues(t, "condition", evaluate(t, "top.ready ? 2'b10 : 2'b11", values),
+		vcd.ReadValue{Time: 0, Value: "1x"}, vcd.ReadValue{Time: 20, Value: "10"}, vcd.ReadValue{Time: 30, Value: "1x"})
+}
+
+func TestVectors(t *testing.T) {
+	values := map[string][]vcd.ReadValue{
+		"top.addr": {{Time: 0, Value: "1010101111001101"}, {Time: 10, Value: "101"}, {Time: 20, Value: "x1"}},
+		"top.hi":   {{Time: 0, Value: "1100"}},
+		"top.lo":   {{Time: 0, Value: "z"}, {Time: 10, Value: "11"}},
+	}
+	expectValues(t, "slice", evaluate(t, "top.addr[15:8]", values),
+		vcd.ReadValue{Time: 0, Value: "10101011"}, vcd.ReadValue{Time: 10, Value: "00000000"}, vcd.ReadValue{Time: 20, Value: "xxxxxxxx"})
+	expectValues(t, "bit", evaluate(t, "top.addr[0]", values),
+		vcd.ReadValue{Time: 0, Value: "1"})
+	expectValues(t, "concat", evaluate(t, "{top.hi, top.lo, 1'b1}", values),
+		vcd.ReadValue{Time: 0, Value: "1100zzzz1"}, vcd.ReadValue{Time: 10, Value: "110000111"})
+	expectValues(t, "equals", evaluate(t, "top.addr == 16'hABCD", values),
+		vcd.ReadValue{Time: 0, Value: "1"}, vcd.ReadValue{Time: 10, Value: "0"}, vcd.ReadValue{Time: 20, Value: "x"})
+	expectValues(t, "arithmetic", evaluate(t, "top.hi + top.lo * 2 - 1", values),
+		vcd.ReadValue{Time: 0, Value: "xxxx"}, vcd.ReadValue{Time: 10, Value: "0001"})
+	expectValues(t, "bitwise", evaluate(t, "top.hi & 4'b1x1x | 4'b000z", values),
+		vcd.ReadValue{Time: 0, Value: "1x0x"})
+	expectValues(t, "constant", evaluate(t, "-8'd1 % 'd10", nil), vcd.ReadValue{Time: 0, Value: "00000101"})
+}
+
+func TestAnalog(t *testing.T) {
+	values := map[string][]vcd.ReadValue{
+		"top.adc": {{Time: 0, Value: "100000000000"}, {Time: 10, Value: "x"}},
+		"top.vin": {{Time: 0, Value: 0.0}, {Time: 10, Value: 1.0}, {Time: 20, Value: 2.5}, {Time: 30, Value: 1.0}, {Time: 40, Value: 0.5}},
+	}
+	scaled := evaluate(t, "real(top.adc) * 3.3 / 4096", values)
+	if len(scaled) != 2 || scaled[0].Value != 1.65 || !math.IsNaN(scaled[1].Value.(float64)) {
+		t.Fatalf("unexpected scaled values %v", scaled)
+	}
+	expectValues(t, "threshold", evaluate(t, "threshold(top.vin, 0.8, 2.0)", values),
+		vcd.ReadValue{Time: 0, Value: "0"}, vcd.ReadValue{Time: 20, Value: "1"}, vcd.ReadValue{Time: 40, Value: "0"})
+	expectValues(t, "compare", evaluate(t, "top.vin > 1.5e0", values),
+		vcd.ReadValue{Time: 0, Value: "0"}, vcd.ReadValue{Time: 20, Value: "1"}, vcd.ReadValue{Time: 30, Value: "0"})
+
+	for _, text := range []string{"top.missing", "top.vin & 1", "top.addr[16]", "top.addr[3:4]", "top.vin[0]", "{top.vin}",
+		"top.name", "(top.valid", "top.valid top.ready", "real(top.adc, 1)", "log(top.adc)", "8'hG", "top.valid ? top.vin : 1", "#"} {
+		if _, e := Parse(text, variables); e == nil {
+			t.Fatalf("expected an error for %s", text)
+		}
+	}
+}
+
+func TestWaveform(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "dump.vcd")
+	writer, e := vcd.New(filename, "1ns")
+	vcdtest.Check(t, e)
+	_, e = writer.RegisterVariables("top", vcd.NewVariable("valid", "wire", 1), vcd.NewVariable("ready", "wire", 1),
+		vcd.NewVariable("data", "wire", 8))
+	vcdtest.Check(t, e)
+	vcdtest.Check(t, writer.SetValue(0, "0", "valid"))
+	vcdtest.Check(t, writer.SetValue(0, "x", "ready"))
+	vcdtest.Check(t, writer.SetValue(0, "165", "data"))
+	vcdtest.Check(t, writer.SetValue(10, "1", "valid"))
+	vcdtest.Check(t, writer.SetValue(20, "1", "ready"))
+	vcdtest.Check(t, writer.SetValue(30, "b1111000z", "data"))
+	writer.Close()
+	reader, e := vcd.NewReader(filename)
+	vcdtest.Check(t, e)
+	defer reader.Close()
+
+	waveform := Load(&reader)
+	_, e = waveform.Derive("fire", "top.valid && top.ready")
+	vcdtest.Check(t, e)
+	_, e = waveform.Derive("nibble", "fire ? top.data[3:0] : 4'h0")
+	vcdtest.Check(t, e)
+	_, e = waveform.Derive("level", "real(top.data) / 50")
+	vcdtest.Check(t, e)
+	if _, e = waveform.Derive("fire", "top.valid"); e == nil {
+		t.Fatal("expected an error for an existing signal")
+	}
+	if value, ok := waveform.At("fire", 15); !ok || value != "x" {
+		t.Fatalf("unexpected value %v at 15", value)
+	}
+	if value, ok := waveform.At("nibble", 25); !ok || value != "0101" {
+		t.Fatalf("unexpected value %v at 25", value)
+	}
+
+	derived := filepath.Join(t.TempDir(), "derived.vcd")
+	writer, e = vcd.New(derived, "1ns")
+	vcdtest.Check(t, e)
+	vcdtest.Check(t, waveform.Write(&writer, "derived"))
+	writer.Close()
+	reader, e = vcd.NewReader(derived)
+	vcdtest.Check(t, e)
+	defer reader.Close()
+	values := reader.ReadAll()
+	expectValues(t, "nibble", values["derived.nibble"], vcd.ReadValue{Time: 0, Value: "0000"},
+		vcd.ReadValue{Time: 10, Value: "0x0x"}, vcd.ReadValue{Time: 20, Value: "0101"}, vcd.ReadValue{Time: 30, Value: "000z"})
+	expectValues(t, "fire", values["derived.fire"], vcd.ReadValue{Time: 0, Value: "0"},
+		vcd.ReadValue{Time: 10, Value: "x"}, vcd.ReadValue{Time: 20, Value: "1"})
+	// The level is unknown once data has a z bit, it keeps its last value
+	if level := values["derived.level"]; len(level) != 1 || level[0].Time != 0 {
+		t.Fatalf("unexpected level %v", level)
+	}
+}
@@ -0,0 +1,308 @@
+package derive
+
+import (
+	"math"
+	"math/big"
+	"strings"
+)
+
+// State of an evaluation over time
+type evaluation struct {
+	values     map[string]value // Current values of the signals
+	thresholds map[*thresholdNode]byte
+}
+
+// Node of a parsed expression with a static type: a vector of width bits or a real
+type node interface {
+	eval(evaluation *evaluation) value
+	width() int
+	isReal() bool
+}
+
+// Returns the value of a node as the given static type
+func convert(v value, width int, isReal bool) value {
+	if isReal {
+		return realValue(v.toReal())
+	}
+	if v.isReal {
+		if math.IsNaN(v.real) || math.IsInf(v.real, 0) {
+			return unknown(width)
+		}
+		integer, _ := big.NewFloat(math.Trunc(v.real)).Int(nil)
+		return fromInteger(integer, width)
+	}
+	return value{bits: v.resize(width)}
+}
+
+type signalNode struct {
+	name string
+	bits int
+	real bool
+}
+
+func (n *signalNode) eval(evaluation *evaluation) value {
+	return evaluation.values[n.name]
+}
+
+func (n *signalNode) width() int   { return n.bits }
+func (n *signalNode) isReal() bool { return n.real }
+
+type constNode struct {
+	value value
+}
+
+func (n *constNode) eval(*evaluation) value { return n.value }
+func (n *constNode) width() int             { return max(len(n.value.bits), 1) }
+func (n *constNode) isReal() bool           { return n.value.isReal }
+
+type unaryNode struct {
+	op      string
+	operand node
+}
+
+func (n *unaryNode) eval(evaluation *evaluation) value {
+	v := n.operand.eval(evaluation)
+	switch n.op {
+	case "!":
+		return bitValue(notBit(v.truth()))
+	case "~":
+		return bitwise(v, v, n.width(), func(a byte, _ byte) byte { return notBit(a) })
+	case "-":
+		if n.isReal() {
+			return realValue(-v.real)
+		}
+		integer, ok := v.integer()
+		if !ok {
+			return unknown(n.width())
+		}
+		return fromInteger(new(big.Int).Neg(integer), n.width())
+	}
+	return v
+}
+
+func (n *unaryNode) width() int {
+	if n.op == "!" {
+		return 1
+	}
+	return n.operand.width()
+}
+
+func (n *unaryNode) isReal() bool {
+	return n.op != "!" && n.operand.isReal()
+}
+
+type binaryNode struct {
+	op    string
+	left  node
+	right node
+}
+
+func (n *binaryNode) eval(evaluation *evaluation) value {
+	a, b := n.left.eval(evaluation), n.right.eval(evaluation)
+	switch n.op {
+	case "&&":
+		return bitValue(andBit(a.truth(), b.truth()))
+	case "||":
+		return bitValue(orBit(a.truth(), b.truth()))
+	case "&":
+		return bitwise(a, b, n.width(), andBit)
+	case "|":
+		return bitwise(a, b, n.width(), orBit)
+	case "^":
+		return bitwise(a, b, n.width(), xorBit)
+	case "==", "!=", "<", "<=", ">", ">=":
+		return n.compare(a, b)
+	}
+	if n.isReal() {
+		x, y := a.toReal(), b.toReal()
+		switch n.op {
+		case "+":
+			return realValue(x + y)
+		case "-":
+			return realValue(x - y)
+		case "*":
+			return realValue(x * y)
+		case "/":
+			return realValue(x / y)
+		}
+		return realValue(math.Mod(x, y))
+	}
+	x, okX := a.integer()
+	y, okY := b.integer()
+	if !okX || !okY || ((n.op == "/" || n.op == "%") && y.Sign() == 0) {
+		return unknown(n.width())
+	}
+	result := new(big.Int)
+	switch n.op {
+	case "+":
+		result.Add(x, y)
+	case "-":
+		result.Sub(x, y)
+	case "*":
+		result.Mul(x, y)
+	case "/":
+		result.Quo(x, y)
+	case "%":
+		result.Rem(x, y)
+	}
+	return fromInteger(result, n.width())
+}
+
+// Compares two values, x when either has an unknown bit or is NaN
+func (n *binaryNode) compare(a value, b value) value {
+	var order int
+	if n.left.isReal() || n.right.isReal() {
+		x, y := a.toReal(), b.toReal()
+		if math.IsNaN(x) || math.IsNaN(y) {
+			return bitValue('x')
+		}
+		order = big.NewFloat(x).Cmp(big.NewFloat(y))
+	} else {
+		x, okX := a.integer()
+		y, okY := b.integer()
+		if !okX || !okY {
+			return bitValue('x')
+		}
+		order = x.Cmp(y)
+	}
+	var result bool
+	switch n.op {
+	case "==":
+		result = order == 0
+	case "!=":
+		result = order != 0
+	case "<":
+		result = order < 0
+	case "<=":
+		result = order <= 0
+	case ">":
+		result = order > 0
+	case ">=":
+		result = order >= 0
+	}
+	if result {
+		return bitValue('1')
+	}
+	return bitValue('0')
+}
+
+func (n *binaryNode) width() int {
+	switch n.op {
+	case "&&", "||", "==", "!=", "<", "<=", ">", ">=":
+		return 1
+	}
+	return max(n.left.width(), n.right.width())
+}
+
+func (n *binaryNode) isReal() bool {
+	switch n.op {
+	case "+", "-", "*", "/", "%":
+		return n.left.isReal() || n.right.isReal()
+	}
+	return false
+}
+
+type conditionNode struct {
+	condition node
+	then      node
+	otherwise node
+}
+
+func (n *conditionNode) eval(evaluation *evaluation) value {
+	condition := n.condition.eval(evaluation).truth()
+	a := convert(n.then.eval(evaluation), n.width(), n.isReal())
+	b := convert(n.otherwise.eval(evaluation), n.width(), n.isReal())
+	switch condition {
+	case '1':
+		return a
+	case '0':
+		return b
+	}
+	return merge(a, b, n.width(), n.isReal())
+}
+
+func (n *conditionNode) width() int {
+	return max(n.then.width(), n.otherwise.width())
+}
+
+func (n *conditionNode) isReal() bool {
+	return n.then.isReal() || n.otherwise.isReal()
+}
+
+// Bits msb down to lsb of a vector
+type sliceNode struct {
+	operand node
+	msb     int
+	lsb     int
+}
+
+func (n *sliceNode) eval(evaluation *evaluation) value {
+	width := n.operand.width()
+	bits := n.operand.eval(evaluation).resize(width)
+	return value{bits: bits[width-1-n.msb : width-n.lsb]}
+}
+
+func (n *sliceNode) width() int   { return n.msb - n.lsb + 1 }
+func (n *sliceNode) isReal() bool { return false }
+
+type concatNode struct {
+	parts []node
+}
+
+func (n *concatNode) eval(evaluation *evaluation) value {
+	var bits strings.Builder
+	for _, part := range n.parts {
+		bits.WriteString(part.eval(evaluation).resize(part.width()))
+	}
+	return value{bits: bits.String()}
+}
+
+func (n *concatNode) width() int {
+	width := 0
+	for _, part := range n.parts {
+		width += part.width()
+	}
+	return width
+}
+
+func (n *concatNode) isReal() bool { return false }
+
+// Unsigned value of a vector as a real
+type realNode struct {
+	operand node
+}
+
+func (n *realNode) eval(evaluation *evaluation) value {
+	return realValue(n.operand.eval(evaluation).toReal())
+}
+
+func (n *realNode) width() int   { return 1 }
+func (n *realNode) isReal() bool { return true }
+
+// Comparator with hysteresis: 1 from high upwards, 0 from low downwards, the previous level in between
+type thresholdNode struct {
+	operand node
+	low     node
+	high    node
+}
+
+func (n *thresholdNode) eval(evaluation *evaluation) value {
+	level, low, high := n.operand.eval(evaluation).toReal(), n.low.eval(evaluation).toReal(), n.high.eval(evaluation).toReal()
+	previous, ok := evaluation.thresholds[n]
+	if !ok {
+		previous = 'x'
+	}
+	switch {
+	case math.IsNaN(level) || math.IsNaN(low) || math.IsNaN(high):
+		previous = 'x'
+	case level >= high:
+		previous = '1'
+	case level <= low:
+		previous = '0'
+	}
+	evaluation.thresholds[n] = previous
+	return bitValue(previous)
+}
+
+func (n *thresholdNode) width() int   { return 1 }
+func (n *thresholdNode) isReal() bool { return false }
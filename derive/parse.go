@@ -0,0 +1,383 @@
+package derive
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/elamre/vcd"
+)
+
+type tokenKind int
+
+const (
+	tokenEnd tokenKind = iota
+	tokenName
+	tokenNumber
+	tokenOperator
+)
+
+type token struct {
+	kind     tokenKind
+	text     string
+	position int
+}
+
+func (t token) String() string {
+	if t.kind == tokenEnd {
+		return "end of expression"
+	}
+	return fmt.Sprintf("\"%s\" at %d", t.text, t.position)
+}
+
+// Operators, the ones of two characters first
+var operators = []string{"&&", "||", "==", "!=", "<=", ">=",
+	"!", "~", "&", "|", "^", "+", "-", "*", "/", "%", "<", ">", "?", ":", "(", ")", "[", "]", "{", "}", ","}
+
+func isNameStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// Splits an expression into names, numbers and operators
+func tokenize(text string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(text); {
+		c := text[i]
+		start := i
+		switch {
+		case c == ' ' || c == '\t':
+			i++
+			continue
+		case isNameStart(c):
+			for i < len(text) && (isNameStart(text[i]) || isDigit(text[i]) || text[i] == '.' || text[i] == '$') {
+				i++
+			}
+			tokens = append(tokens, token{tokenName, text[start:i], start})
+			continue
+		case isDigit(c) || c == '\'':
+			for i < len(text) && (isDigit(text[i]) || text[i] == '_') {
+				i++
+			}
+			if i < len(text) && text[i] == '\'' {
+				// Based number such as 8'hFF
+				i += 2
+				for i < len(text) && strings.ContainsRune("0123456789abcdefABCDEFxXzZ_", rune(text[i])) {
+					i++
+				}
+			} else {
+				for i < len(text) && (isDigit(text[i]) || strings.ContainsRune(".eE", rune(text[i])) ||
+					((text[i] == '-' || text[i] == '+') && strings.ContainsRune("eE", rune(text[i-1])))) {
+					i++
+				}
+			}
+			tokens = append(tokens, token{tokenNumber, text[start:min(i, len(text))], start})
+			continue
+		}
+		found := false
+		for _, operator := range operators {
+			if strings.HasPrefix(text[i:], operator) {
+				tokens = append(tokens, token{tokenOperator, operator, start})
+				i += len(operator)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unexpected character %q at %d", c, i)
+		}
+	}
+	return append(tokens, token{kind: tokenEnd, position: len(text)}), nil
+}
+
+// Parses a number such as 12, 3.3, 1e-3, 'b101 or 8'hxF
+func parseNumber(text string) (value, error) {
+	quote := strings.IndexByte(text, '\'')
+	if quote < 0 {
+		if strings.ContainsAny(text, ".eE") {
+			real, err := strconv.ParseFloat(text, 64)
+			return realValue(real), err
+		}
+		integer, ok := new(big.Int).SetString(strings.ReplaceAll(text, "_", ""), 10)
+		if !ok {
+			return value{}, fmt.Errorf("invalid number %s", text)
+		}
+		return value{bits: integer.Text(2)}, nil
+	}
+	if quote+2 >= len(text) {
+		return value{}, fmt.Errorf("invalid number %s", text)
+	}
+	digits := strings.ToLower(strings.ReplaceAll(text[quote+2:], "_", ""))
+	var bits string
+	switch base := strings.ToLower(text[quote+1 : quote+2]); base {
+	case "d":
+		if digits == "x" || digits == "z" {
+			bits = digits
+		} else if integer, ok := new(big.Int).SetString(digits, 10); ok {
+			bits = integer.Text(2)
+		} else {
+			return value{}, fmt.Errorf("invalid number %s", text)
+		}
+	case "b", "o", "h":
+		// Bits per digit
+		digitBits := map[string]int{"b": 1, "o": 3, "h": 4}[base]
+		for _, digit := range digits {
+			if digit == 'x' || digit == 'z' {
+				bits += strings.Repeat(string(digit), digitBits)
+				continue
+			}
+			n, err := strconv.ParseUint(string(digit), 1<<digitBits, 8)
+			if err != nil {
+				return value{}, fmt.Errorf("invalid digit %c in %s", digit, text)
+			}
+			bits += fmt.Sprintf("%0*b", digitBits, n)
+		}
+	default:
+		return value{}, fmt.Errorf("invalid base in %s", text)
+	}
+	if bits == "" {
+		return value{}, fmt.Errorf("invalid number %s", text)
+	}
+	if quote == 0 {
+		return value{bits: bits}, nil
+	}
+	size, err := strconv.Atoi(text[:quote])
+	if err != nil || size < 1 {
+		return value{}, fmt.Errorf("invalid size in %s", text)
+	}
+	if len(bits) >= size {
+		return value{bits: bits[len(bits)-size:]}, nil
+	}
+	// Numbers starting with x or z are extended with x or z
+	fill := "0"
+	if bits[0] == 'x' || bits[0] == 'z' {
+		fill = bits[:1]
+	}
+	return value{bits: strings.Repeat(fill, size-len(bits)) + bits}, nil
+}
+
+type parser struct {
+	tokens    []token
+	index     int
+	variables map[string]vcd.VcdDataType
+	signals   []string
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.index]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.index]
+	if t.kind != tokenEnd {
+		p.index++
+	}
+	return t
+}
+
+// Consumes the operator when it is next
+func (p *parser) accept(operator string) bool {
+	if t := p.peek(); t.kind == tokenOperator && t.text == operator {
+		p.index++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(operator string) error {
+	if !p.accept(operator) {
+		return fmt.Errorf("expected \"%s\" instead of %s", operator, p.peek())
+	}
+	return nil
+}
+
+// Binary operators from the lowest to the highest precedence
+var precedence = [][]string{{"||"}, {"&&"}, {"|"}, {"^"}, {"&"}, {"==", "!="}, {"<", "<=", ">", ">="}, {"+", "-"}, {"*", "/", "%"}}
+
+// Parses condition ? then : otherwise, the operator with the lowest precedence
+func (p *parser) expression() (node, error) {
+	condition, err := p.binary(0)
+	if err != nil || !p.accept("?") {
+		return condition, err
+	}
+	then, err := p.expression()
+	if err != nil {
+		return nil, err
+	}
+	if err = p.expect(":"); err != nil {
+		return nil, err
+	}
+	otherwise, err := p.expression()
+	if err != nil {
+		return nil, err
+	}
+	if then.isReal() != otherwise.isReal() {
+		return nil, fmt.Errorf("condition mixes a real and a vector, convert with real()")
+	}
+	return &conditionNode{condition, then, otherwise}, nil
+}
+
+func (p *parser) binary(level int) (node, error) {
+	if level == len(precedence) {
+		return p.unary()
+	}
+	left, err := p.binary(level + 1)
+	for err == nil {
+		t := p.peek()
+		if t.kind != tokenOperator || !stringIn(t.text, precedence[level]) {
+			break
+		}
+		p.next()
+		var right node
+		if right, err = p.binary(level + 1); err != nil {
+			break
+		}
+		if stringIn(t.text, []string{"&", "|", "^"}) && (left.isReal() || right.isReal()) {
+			return nil, fmt.Errorf("bitwise operator %s applied to a real", t)
+		}
+		left = &binaryNode{t.text, left, right}
+	}
+	return left, err
+}
+
+func (p *parser) unary() (node, error) {
+	t := p.peek()
+	if t.kind == tokenOperator && stringIn(t.text, []string{"!", "~", "-", "+"}) {
+		p.next()
+		operand, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		if t.text == "~" && operand.isReal() {
+			return nil, fmt.Errorf("bitwise operator %s applied to a real", t)
+		}
+		return &unaryNode{t.text, operand}, nil
+	}
+	return p.postfix()
+}
+
+// Parses bit selects such as [15:8] and [3] after a primary
+func (p *parser) postfix() (node, error) {
+	operand, err := p.primary()
+	for err == nil && p.accept("[") {
+		t := p.peek()
+		var msb, lsb int
+		if msb, err = p.bitIndex(); err != nil {
+			break
+		}
+		lsb = msb
+		if p.accept(":") {
+			if lsb, err = p.bitIndex(); err != nil {
+				break
+			}
+		}
+		if err = p.expect("]"); err != nil {
+			break
+		}
+		switch {
+		case operand.isReal():
+			return nil, fmt.Errorf("bit select %s of a real", t)
+		case msb < lsb || msb >= operand.width():
+			return nil, fmt.Errorf("bit select [%d:%d] at %d outside of [%d:0]", msb, lsb, t.position, operand.width()-1)
+		}
+		operand = &sliceNode{operand, msb, lsb}
+	}
+	return operand, err
+}
+
+// Parses a bit index
+func (p *parser) bitIndex() (int, error) {
+	t := p.next()
+	index, err := strconv.Atoi(t.text)
+	if t.kind != tokenNumber || err != nil || index < 0 {
+		return 0, fmt.Errorf("expected a bit index instead of %s", t)
+	}
+	return index, nil
+}
+
+func (p *parser) primary() (node, error) {
+	t := p.next()
+	switch {
+	case t.kind == tokenNumber:
+		v, err := parseNumber(t.text)
+		if err != nil {
+			return nil, fmt.Errorf("%v at %d", err, t.position)
+		}
+		return &constNode{v}, nil
+	case t.kind == tokenName && p.accept("("):
+		return p.call(t)
+	case t.kind == tokenName:
+		variable, ok := p.variables[t.text]
+		if !ok {
+			return nil, fmt.Errorf("unknown signal %s", t)
+		}
+		if variable.VariableType == "string" {
+			return nil, fmt.Errorf("string signal %s can not be used in expressions", t)
+		}
+		if !stringIn(t.text, p.signals) {
+			p.signals = append(p.signals, t.text)
+		}
+		return &signalNode{t.text, max(variable.BitDepth, 1), variable.VariableType == "real"}, nil
+	case t.kind == tokenOperator && t.text == "(":
+		inner, err := p.expression()
+		if err != nil {
+			return nil, err
+		}
+		return inner, p.expect(")")
+	case t.kind == tokenOperator && t.text == "{":
+		concat := &concatNode{}
+		for {
+			part, err := p.expression()
+			if err != nil {
+				return nil, err
+			}
+			if part.isReal() {
+				return nil, fmt.Errorf("concatenation of a real at %d", t.position)
+			}
+			concat.parts = append(concat.parts, part)
+			if !p.accept(",") {
+				return concat, p.expect("}")
+			}
+		}
+	}
+	return nil, fmt.Errorf("unexpected %s", t)
+}
+
+// Parses the arguments of a function call such as real(adc) or threshold(vin, 0.8, 2.0)
+func (p *parser) call(function token) (node, error) {
+	var arguments []node
+	for !p.accept(")") {
+		if len(arguments) > 0 {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		argument, err := p.expression()
+		if err != nil {
+			return nil, err
+		}
+		arguments = append(arguments, argument)
+	}
+	switch {
+	case function.text == "real" && len(arguments) == 1:
+		return &realNode{arguments[0]}, nil
+	case function.text == "threshold" && len(arguments) == 3:
+		return &thresholdNode{arguments[0], arguments[1], arguments[2]}, nil
+	case function.text == "real" || function.text == "threshold":
+		return nil, fmt.Errorf("wrong number of arguments for %s", function)
+	}
+	return nil, fmt.Errorf("unknown function %s", function)
+}
+
+func stringIn(s string, list []string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,153 @@
+package derive
+
+import (
+	"math"
+	"math/big"
+	"strings"
+)
+
+// Value of an expression, either a vector of 4-state bits or a real
+// Unknown reals are NaN
+type value struct {
+	bits   string // Most significant bit first, each 0, 1, x or z
+	real   float64
+	isReal bool
+}
+
+func realValue(real float64) value {
+	return value{real: real, isReal: true}
+}
+
+func bitValue(bit byte) value {
+	return value{bits: string(bit)}
+}
+
+// Returns width bits, unknown
+func unknown(width int) value {
+	return value{bits: strings.Repeat("x", width)}
+}
+
+// Returns the bits zero extended or truncated to width
+func (v value) resize(width int) string {
+	if len(v.bits) >= width {
+		return v.bits[len(v.bits)-width:]
+	}
+	return strings.Repeat("0", width-len(v.bits)) + v.bits
+}
+
+// Returns whether the value is true: 1 when a bit is 1 or a real is not 0,
+// 0 when all bits are 0 or the real is 0, x otherwise
+func (v value) truth() byte {
+	if v.isReal {
+		switch {
+		case math.IsNaN(v.real):
+			return 'x'
+		case v.real != 0:
+			return '1'
+		}
+		return '0'
+	}
+	switch {
+	case strings.ContainsRune(v.bits, '1'):
+		return '1'
+	case strings.Trim(v.bits, "0") == "":
+		return '0'
+	}
+	return 'x'
+}
+
+// Returns the unsigned value of the bits, false when a bit is x or z
+func (v value) integer() (*big.Int, bool) {
+	if strings.Trim(v.bits, "01") != "" {
+		return nil, false
+	}
+	integer, _ := new(big.Int).SetString("0"+v.bits, 2)
+	return integer, true
+}
+
+// Returns the value as a real, NaN when a bit is x or z
+func (v value) toReal() float64 {
+	if v.isReal {
+		return v.real
+	}
+	integer, ok := v.integer()
+	if !ok {
+		return math.NaN()
+	}
+	real, _ := new(big.Float).SetInt(integer).Float64()
+	return real
+}
+
+// Returns integer modulo 2^width as a vector of width bits
+func fromInteger(integer *big.Int, width int) value {
+	modulus := new(big.Int).Lsh(big.NewInt(1), uint(width))
+	integer = new(big.Int).Mod(integer, modulus)
+	return value{bits: value{bits: integer.Text(2)}.resize(width)}
+}
+
+// 4-state truth tables of a single bit, z is treated as x
+func andBit(a byte, b byte) byte {
+	switch {
+	case a == '0' || b == '0':
+		return '0'
+	case a == '1' && b == '1':
+		return '1'
+	}
+	return 'x'
+}
+
+func orBit(a byte, b byte) byte {
+	switch {
+	case a == '1' || b == '1':
+		return '1'
+	case a == '0' && b == '0':
+		return '0'
+	}
+	return 'x'
+}
+
+func xorBit(a byte, b byte) byte {
+	if (a != '0' && a != '1') || (b != '0' && b != '1') {
+		return 'x'
+	}
+	if a == b {
+		return '0'
+	}
+	return '1'
+}
+
+func notBit(a byte) byte {
+	switch a {
+	case '0':
+		return '1'
+	case '1':
+		return '0'
+	}
+	return 'x'
+}
+
+// Applies a bit operation to the bits of a and b, both extended to width
+func bitwise(a value, b value, width int, op func(byte, byte) byte) value {
+	x, y := a.resize(width), b.resize(width)
+	bits := make([]byte, width)
+	for i := range bits {
+		bits[i] = op(x[i], y[i])
+	}
+	return value{bits: string(bits)}
+}
+
+// Returns a where a and b agree and x elsewhere, the result of a condition which is x
+func merge(a value, b value, width int, isReal bool) value {
+	if isReal {
+		if x, y := a.toReal(), b.toReal(); x == y {
+			return realValue(x)
+		}
+		return realValue(math.NaN())
+	}
+	return bitwise(a, b, width, func(x byte, y byte) byte {
+		if x == y && (x == '0' || x == '1') {
+			return x
+		}
+		return 'x'
+	})
+}
@@ -0,0 +1,589 @@
+package vcd
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Block types of an FST file
+const (
+	fstBlockHeader               = 0
+	fstBlockValueChange          = 1
+	fstBlockBlackout             = 2
+	fstBlockGeometry             = 3
+	fstBlockHierarchy            = 4
+	fstBlockValueChangeDynAlias  = 5
+	fstBlockHierarchyLz4         = 6
+	fstBlockHierarchyLz4Duo      = 7
+	fstBlockValueChangeDynAlias2 = 8
+	fstBlockZWrapper             = 254
+	fstBlockSkip                 = 255
+)
+
+// Hierarchy entries which are not variables
+const (
+	fstScopeModule = 0
+	fstAttrBegin   = 252
+	fstAttrEnd     = 253
+	fstScope       = 254
+	fstUpscope     = 255
+)
+
+// Variable types as stored in the hierarchy block
+const (
+	fstVarReal   = 3
+	fstVarWire   = 16
+	fstVarPort   = 18
+	fstVarString = 21
+)
+
+// Names of the FST variable types, indexed by type
+var fstVarTypeNames = []string{
+	"event", "integer", "parameter", "real", "real_parameter", "reg", "supply0", "supply1", "time", "tri",
+	"triand", "trior", "trireg", "tri0", "tri1", "wand", "wire", "wor", "port", "sparray", "realtime",
+	"string", "bit", "logic", "int", "shortint", "longint", "byte", "enum", "shortreal",
+}
+
+const (
+	fstHeaderLength  = 329
+	fstVersionLength = 128
+	fstDateLength    = 119
+	// Value changes are kept in memory until this many bytes are pending, then written as one block
+	fstBlockSize = 4 << 20
+	// Chains shorter than this are not worth compressing
+	fstCompressMin = 32
+)
+
+// Written in the header so readers can detect the byte order of doubles
+const fstEndianTest = 2.7182818284590452354
+
+// Single bit values which are not 0 or 1, in the order of their FST encoding
+const fstScalarStates = "xzhuwl-?"
+
+type fstVariable struct {
+	VcdDataType
+	handle    int
+	length    int // Bytes in the value frame, 0 for variable length values
+	current   []byte
+	frame     []byte
+	chain     []byte
+	lastIndex int
+}
+
+type FstWriter struct {
+	loadedFile          *os.File
+	timescale           int8
+	date                string
+	version             string
+	hierarchy           bytes.Buffer
+	scopeCount          uint64
+	variables           []*fstVariable
+	stringIdentifierMap map[string]*fstVariable
+	paths               []string
+	started             bool
+	previousTime        uint64
+	startTime           uint64
+	endTime             uint64
+	blockStart          uint64
+	times               []uint64
+	pending             int
+	sectionCount        uint64
+}
+
+// Creates a new FSTWriter object, the FST counterpart of New
+// The Date is set to the current Date
+// Timescale can be one of the following: 1-10-100 combined with unit: s-ms-us-ns-ps-fs
+func NewFst(filename string, timeScale string) (FstWriter, error) {
+	if !strings.HasSuffix(filename, ".fst") {
+		filename = filename + ".fst"
+	}
+	exponent, err := timescaleExponent(timeScale)
+	if err != nil {
+		return FstWriter{}, err
+	}
+	f, err := os.Create(filename)
+	writer := FstWriter{
+		loadedFile:          f,
+		timescale:           exponent,
+		date:                time.Now().Format(time.ANSIC) + "\n",
+		stringIdentifierMap: make(map[string]*fstVariable),
+	}
+	if err == nil {
+		// Reserve room for the header, it is rewritten once the totals are known
+		check2(f.Write(writer.header()))
+	}
+	return writer, err
+}
+
+// Converts a timescale such as 10ps to the power of ten FST stores
+func timescaleExponent(timeScale string) (int8, error) {
+	unitStart := strings.IndexFunc(timeScale, func(r rune) bool { return r < '0' || r > '9' })
+	if unitStart <= 0 {
+		return 0, fmt.Errorf("invalid timescale: \"%s\"", timeScale)
+	}
+	number, _ := strconv.Atoi(timeScale[:unitStart])
+	unit := strings.TrimSpace(timeScale[unitStart:])
+	numberIndex, unitIndex := -1, -1
+	for i, n := range supportedTimescale {
+		if n == number {
+			numberIndex = i
+		}
+	}
+	for i, u := range supportedTimescaleUnit {
+		if u == unit {
+			unitIndex = i
+		}
+	}
+	if numberIndex < 0 || unitIndex < 0 {
+		return 0, fmt.Errorf("invalid timescale: \"%s\" use 1-10-100 combined with unit: %v", timeScale, supportedTimescaleUnit)
+	}
+	return int8(numberIndex - 3*unitIndex), nil
+}
+
+// Converts the identifier handle of FST into a VCD style identifier
+func fstIdentifier(handle int) string {
+	id := ""
+	for handle > 0 {
+		handle--
+		id += string(rune('!' + handle%94))
+		handle /= 94
+	}
+	return id
+}
+
+func writeUint64(buffer *bytes.Buffer, value uint64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], value)
+	buffer.Write(b[:])
+}
+
+func writeVarint(buffer *bytes.Buffer, value uint64) {
+	var b [binary.MaxVarintLen64]byte
+	buffer.Write(b[:binary.PutUvarint(b[:], value)])
+}
+
+func writeFixedString(buffer *bytes.Buffer, value string, length int) {
+	b := make([]byte, length)
+	copy(b[:length-1], value)
+	buffer.Write(b)
+}
+
+// Compresses data with zlib, returns nil when compression does not make it smaller
+func fstCompress(data []byte) []byte {
+	var compressed bytes.Buffer
+	z := zlib.NewWriter(&compressed)
+	check2(z.Write(data))
+	check(z.Close())
+	if compressed.Len() >= len(data) {
+		return nil
+	}
+	return compressed.Bytes()
+}
+
+func (fst *FstWriter) header() []byte {
+	var b bytes.Buffer
+	b.WriteByte(fstBlockHeader)
+	writeUint64(&b, fstHeaderLength)
+	writeUint64(&b, fst.startTime)
+	writeUint64(&b, fst.endTime)
+	check(binary.Write(&b, binary.LittleEndian, fstEndianTest))
+	writeUint64(&b, fstBlockSize)
+	writeUint64(&b, fst.scopeCount)
+	writeUint64(&b, uint64(len(fst.variables)))
+	writeUint64(&b, uint64(len(fst.variables)))
+	writeUint64(&b, fst.sectionCount)
+	b.WriteByte(byte(fst.timescale))
+	writeFixedString(&b, fst.version, fstVersionLength)
+	writeFixedString(&b, fst.date, fstDateLength)
+	b.WriteByte(0) // Verilog file type
+	writeUint64(&b, 0)
+	return b.Bytes()
+}
+
+// Register variables
+// Variables is an array of VcdDatatTypes
+// See writer.go -> NewVariable
+func (fst *FstWriter) RegisterVariableList(module string, variables []VcdDataType) (map[string]VcdDataType, error) {
+	return fst.RegisterVariables(module, variables...)
+}
+
+// Register variables
+// Variables is an array of VcdDatatTypes
+// See writer.go -> NewVariable
+func (fst *FstWriter) RegisterVariables(module string, variables ...VcdDataType) (map[string]VcdDataType, error) {
+	if fst.started {
+		return nil, fmt.Errorf("can not register variables after setting values")
+	}
+	fst.hierarchy.WriteByte(fstScope)
+	fst.hierarchy.WriteByte(fstScopeModule)
+	fst.hierarchy.WriteString(module + "\x00\x00")
+	fst.scopeCount++
+	for _, variable := range variables {
+		if variable.VariableType == "port" {
+			return nil, fmt.Errorf("port variables are not supported by FST: \"%s\"", variable.VariableName)
+		}
+		v := &fstVariable{VcdDataType: variable, handle: len(fst.variables) + 1}
+		if err := initVariable(&v.VcdDataType, fstIdentifier(v.handle)); err != nil {
+			return nil, err
+		}
+		varType, length := byte(fstVarWire), variable.BitDepth
+		switch variable.VariableType {
+		case "real":
+			varType, length = fstVarReal, 8
+			v.current = make([]byte, 8)
+		case "string":
+			varType, length = fstVarString, 0
+		default:
+			v.current = bytes.Repeat([]byte{'x'}, length)
+		}
+		v.length = length
+		fst.hierarchy.WriteByte(varType)
+		fst.hierarchy.WriteByte(0) // Implicit direction
+		fst.hierarchy.WriteString(variable.VariableName + "\x00")
+		writeVarint(&fst.hierarchy, uint64(length))
+		writeVarint(&fst.hierarchy, 0) // Not an alias
+		fst.variables = append(fst.variables, v)
+		fst.stringIdentifierMap[variable.VariableName] = v
+		fst.paths = append(fst.paths, module+"."+variable.VariableName)
+	}
+	fst.hierarchy.WriteByte(fstUpscope)
+
+	registered := make(map[string]VcdDataType)
+	for name, v := range fst.stringIdentifierMap {
+		registered[name] = v.VcdDataType
+	}
+	return registered, nil
+}
+
+// Returns the registered variables in order of registration, named by their full path such as example.logic.mosi
+func (fst *FstWriter) Variables() []VcdDataType {
+	variables := make([]VcdDataType, len(fst.variables))
+	for i, v := range fst.variables {
+		variables[i] = v.VcdDataType
+		variables[i].VariableName = fst.paths[i]
+	}
+	return variables
+}
+
+// Converts a value to the bytes stored by FST
+func (v *fstVariable) encode(value string) ([]byte, error) {
+	switch v.VariableType {
+	case "real":
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, err
+		}
+		b := make([]byte, 8)
+		binary.LittleEndian.PutUint64(b, math.Float64bits(f))
+		return b, nil
+	case "string":
+		return []byte(value), nil
+	}
+	formatted, err := v.marshal.format(value)
+	if err != nil {
+		return nil, err
+	}
+	bits := formatted[1:]
+	if bits == "x" || bits == "z" {
+		return bytes.Repeat([]byte(bits), v.length), nil
+	}
+	if len(bits) > v.length {
+		return nil, fmt.Errorf("vector %s does not fit in %d bits", value, v.length)
+	}
+	return []byte(strings.Repeat("0", v.length-len(bits)) + bits), nil
+}
+
+// Appends a value change to the chain of the variable for the current block
+func (v *fstVariable) appendChange(index int, value []byte) {
+	delta := uint64(index - v.lastIndex)
+	v.lastIndex = index
+	var b [binary.MaxVarintLen64]byte
+	switch {
+	case v.length == 0:
+		v.chain = append(v.chain, b[:binary.PutUvarint(b[:], delta)]...)
+		v.chain = append(v.chain, b[:binary.PutUvarint(b[:], uint64(len(value)))]...)
+		v.chain = append(v.chain, value...)
+	case v.VariableType == "real":
+		v.chain = append(v.chain, b[:binary.PutUvarint(b[:], delta<<1|1)]...)
+		v.chain = append(v.chain, value...)
+	case v.length == 1:
+		if value[0] == '0' || value[0] == '1' {
+			v.chain = append(v.chain, b[:binary.PutUvarint(b[:], delta<<2|uint64(value[0]-'0')<<1)]...)
+		} else {
+			state := strings.IndexByte(fstScalarStates, value[0])
+			if state < 0 {
+				state = 0
+			}
+			v.chain = append(v.chain, b[:binary.PutUvarint(b[:], delta<<4|uint64(state)<<1|1)]...)
+		}
+	case bytes.Count(value, []byte{'0'})+bytes.Count(value, []byte{'1'}) == len(value):
+		// Pure binary values are packed 8 bits per byte, most significant bit first
+		v.chain = append(v.chain, b[:binary.PutUvarint(b[:], delta<<1)]...)
+		packed := make([]byte, (len(value)+7)/8)
+		for i, bit := range value {
+			packed[i/8] |= (bit - '0') << (7 - i%8)
+		}
+		v.chain = append(v.chain, packed...)
+	default:
+		v.chain = append(v.chain, b[:binary.PutUvarint(b[:], delta<<1|1)]...)
+		v.chain = append(v.chain, value...)
+	}
+}
+
+// Sets a value for a specific variable
+// Time in timeunits, always has to be the same, or larger as the previous time
+// Returns an error when the value can not be converted, or when there are problems with the time
+func (fst *FstWriter) SetValue(time uint64, value string, variableName string) error {
+	v, ok := fst.stringIdentifierMap[variableName]
+	if !ok {
+		return fmt.Errorf("unknown variable: \"%s\"", variableName)
+	}
+	if fst.started && time < fst.previousTime {
+		return fmt.Errorf("changing value from an earlier time: %d < %d", time, fst.previousTime)
+	}
+	encoded, err := v.encode(value)
+	if err != nil {
+		return err
+	}
+	if !fst.started {
+		fst.started = true
+		fst.startTime, fst.blockStart, fst.previousTime = time, time, time
+	}
+	if time > fst.endTime {
+		fst.endTime = time
+	}
+	if fst.sectionCount == 0 && len(fst.times) == 0 && time == fst.startTime {
+		// Initial values go straight into the first frame
+		v.current = encoded
+		return nil
+	}
+	if time != fst.previousTime || len(fst.times) == 0 {
+		if fst.sectionCount == 0 && len(fst.times) == 0 {
+			fst.snapshotFrame()
+		}
+		if fst.pending >= fstBlockSize {
+			check(fst.flushBlock())
+			fst.blockStart = time
+		}
+		fst.times = append(fst.times, time)
+		fst.previousTime = time
+	}
+	before := len(v.chain)
+	v.appendChange(len(fst.times)-1, encoded)
+	fst.pending += len(v.chain) - before
+	v.current = encoded
+	return nil
+}
+
+// Sets the values of multiple variables at the time of the last value change
+func (fst *FstWriter) DumpValues(identifierToValue map[string]string) {
+	for name, value := range identifierToValue {
+		check(fst.SetValue(fst.previousTime, value, name))
+	}
+}
+
+// Sets the Comment in the fst. Can be used together with the SetVersion
+func (fst *FstWriter) SetComment(comment string) *FstWriter {
+	fst.hierarchy.WriteByte(fstAttrBegin)
+	fst.hierarchy.WriteByte(0) // Miscellaneous attribute
+	fst.hierarchy.WriteByte(0) // Comment
+	fst.hierarchy.WriteString(comment + "\x00")
+	writeVarint(&fst.hierarchy, 0)
+	return fst
+}
+
+// Sets the Version in the fst. Can be used together with the SetComment
+func (fst *FstWriter) SetVersion(version string) *FstWriter {
+	fst.version = version
+	return fst
+}
+
+// Sets the Version and Comment in the fst, skipping empty ones
+func (fst *FstWriter) SetMetadata(version string, comment string) {
+	if version != "" {
+		fst.SetVersion(version)
+	}
+	if comment != "" {
+		fst.SetComment(comment)
+	}
+}
+
+// Extends the end time of the dump
+func (fst *FstWriter) SetTimestamp(time uint64) {
+	if time > fst.endTime {
+		fst.endTime = time
+	}
+}
+
+// Writes the pending value changes as a value change block
+func (fst *FstWriter) flushBlock() error {
+	var body bytes.Buffer
+	end := fst.blockStart
+	if len(fst.times) > 0 {
+		end = fst.times[len(fst.times)-1]
+	}
+	writeUint64(&body, fst.blockStart)
+	writeUint64(&body, end)
+
+	var frame bytes.Buffer
+	for _, v := range fst.variables {
+		if v.length > 0 {
+			frame.Write(v.frame)
+		}
+	}
+	writeUint64(&body, uint64(frame.Len()+fst.pending))
+	writeVarint(&body, uint64(frame.Len()))
+	if compressed := fstCompress(frame.Bytes()); compressed != nil {
+		writeVarint(&body, uint64(len(compressed)))
+		writeVarint(&body, uint64(len(fst.variables)))
+		body.Write(compressed)
+	} else {
+		writeVarint(&body, uint64(frame.Len()))
+		writeVarint(&body, uint64(len(fst.variables)))
+		body.Write(frame.Bytes())
+	}
+
+	// Value change chains, positions are relative to the pack type
+	writeVarint(&body, uint64(len(fst.variables)))
+	body.WriteByte('Z')
+	positions := make([]uint64, len(fst.variables))
+	position := uint64(1)
+	for i, v := range fst.variables {
+		if len(v.chain) == 0 {
+			continue
+		}
+		positions[i] = position
+		start := body.Len()
+		var compressed []byte
+		if len(v.chain) >= fstCompressMin {
+			compressed = fstCompress(v.chain)
+		}
+		if compressed != nil {
+			writeVarint(&body, uint64(len(v.chain)))
+			body.Write(compressed)
+		} else {
+			writeVarint(&body, 0)
+			body.Write(v.chain)
+		}
+		position += uint64(body.Len() - start)
+	}
+	indexStart := body.Len()
+	previous, zeros := uint64(0), uint64(0)
+	for _, p := range positions {
+		if p == 0 {
+			zeros++
+			continue
+		}
+		if zeros > 0 {
+			writeVarint(&body, zeros<<1)
+			zeros = 0
+		}
+		writeVarint(&body, (p-previous)<<1|1)
+		previous = p
+	}
+	if zeros > 0 {
+		writeVarint(&body, zeros<<1)
+	}
+	writeUint64(&body, uint64(body.Len()-indexStart))
+
+	var timeTable bytes.Buffer
+	previousTime := uint64(0)
+	for _, t := range fst.times {
+		writeVarint(&timeTable, t-previousTime)
+		previousTime = t
+	}
+	compressedTimes := fstCompress(timeTable.Bytes())
+	if compressedTimes == nil {
+		compressedTimes = timeTable.Bytes()
+	}
+	body.Write(compressedTimes)
+	writeUint64(&body, uint64(timeTable.Len()))
+	writeUint64(&body, uint64(len(compressedTimes)))
+	writeUint64(&body, uint64(len(fst.times)))
+
+	var block bytes.Buffer
+	block.WriteByte(fstBlockValueChangeDynAlias)
+	writeUint64(&block, uint64(body.Len()+8))
+	block.Write(body.Bytes())
+	if _, err := fst.loadedFile.Write(block.Bytes()); err != nil {
+		return err
+	}
+
+	fst.sectionCount++
+	fst.times = fst.times[:0]
+	fst.pending = 0
+	fst.snapshotFrame()
+	return nil
+}
+
+// Takes the current values as the frame of the next block
+func (fst *FstWriter) snapshotFrame() {
+	for _, v := range fst.variables {
+		v.frame = append(v.frame[:0], v.current...)
+		v.chain = v.chain[:0]
+		v.lastIndex = 0
+	}
+}
+
+func (fst *FstWriter) writeGeometry() error {
+	var geometry bytes.Buffer
+	for _, v := range fst.variables {
+		switch {
+		case v.VariableType == "real":
+			writeVarint(&geometry, 0)
+		case v.length == 0:
+			writeVarint(&geometry, 0xFFFFFFFF)
+		default:
+			writeVarint(&geometry, uint64(v.length))
+		}
+	}
+	data := fstCompress(geometry.Bytes())
+	if data == nil {
+		data = geometry.Bytes()
+	}
+	var block bytes.Buffer
+	block.WriteByte(fstBlockGeometry)
+	writeUint64(&block, uint64(len(data)+24))
+	writeUint64(&block, uint64(geometry.Len()))
+	writeUint64(&block, uint64(len(fst.variables)))
+	block.Write(data)
+	_, err := fst.loadedFile.Write(block.Bytes())
+	return err
+}
+
+func (fst *FstWriter) writeHierarchy() error {
+	var compressed bytes.Buffer
+	z := gzip.NewWriter(&compressed)
+	check2(z.Write(fst.hierarchy.Bytes()))
+	check(z.Close())
+	var block bytes.Buffer
+	block.WriteByte(fstBlockHierarchy)
+	writeUint64(&block, uint64(compressed.Len()+16))
+	writeUint64(&block, uint64(fst.hierarchy.Len()))
+	block.Write(compressed.Bytes())
+	_, err := fst.loadedFile.Write(block.Bytes())
+	return err
+}
+
+// Writes the remaining blocks, completes the header and closes the file
+func (fst *FstWriter) Close() {
+	if fst.sectionCount == 0 && len(fst.times) == 0 {
+		// Only initial values were set, the first frame holds them
+		fst.snapshotFrame()
+	}
+	if fst.sectionCount == 0 || len(fst.times) > 0 {
+		check(fst.flushBlock())
+	}
+	check(fst.writeGeometry())
+	check(fst.writeHierarchy())
+	check2(fst.loadedFile.WriteAt(fst.header(), 0))
+	check(fst.loadedFile.Close())
+}
@@ -0,0 +1,460 @@
+package vcd
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strings"
+)
+
+type fstBlock struct {
+	blockType byte
+	offset    int64
+	length    int64
+}
+
+type fstEvent struct {
+	timeIndex int
+	handle    int
+	value     interface{}
+}
+
+type FstReader struct {
+	loadedFile *os.File
+	data       io.ReaderAt
+
+	Date              string
+	Timescale         string
+	Version           string
+	Comment           string
+	identifierNameMap map[string]VcdDataType
+	aliases           map[int][]string // Identifiers of further variables sharing the values of a handle
+
+	doubleOrder binary.ByteOrder
+	lengths     []int // Bytes per value of every handle, 0 for variable length values
+	reals       []bool
+	blocks      []fstBlock
+	nextBlock   int
+	times       []uint64
+	events      []fstEvent
+	nextEvent   int
+	pending     []string // Aliases still to return for the last event
+}
+
+// Opens an FST file, the FST counterpart of NewReader
+func NewFstReader(filename string) (FstReader, error) {
+	reader := FstReader{}
+	f, err := os.Open(filename)
+	reader.loadedFile = f
+	reader.data = f
+	reader.identifierNameMap = nil
+	return reader, err
+}
+
+func (reader FstReader) Close() {
+	_ = reader.loadedFile.Close()
+}
+
+func (reader FstReader) GetIdentifiers() map[string]VcdDataType {
+	return reader.identifierNameMap
+}
+
+// Returns the variables of the header sorted by their full path
+func (reader FstReader) Variables() []VcdDataType {
+	return sortedVariables(reader.identifierNameMap)
+}
+
+func (reader FstReader) Metadata() WaveMetadata {
+	return WaveMetadata{Date: reader.Date, Timescale: reader.Timescale, Version: reader.Version, Comment: reader.Comment}
+}
+
+func (reader *FstReader) readAt(offset int64, length int64) []byte {
+	b := make([]byte, length)
+	check2(reader.data.ReadAt(b, offset))
+	return b
+}
+
+// Converts the power of ten FST stores to a timescale such as 10ps
+func timescaleString(exponent int8) string {
+	unit := int(math.Floor(float64(exponent) / 3))
+	if unit > 0 || -unit >= len(supportedTimescaleUnit) {
+		return fmt.Sprintf("1e%ds", exponent)
+	}
+	return fmt.Sprintf("%d%s", supportedTimescale[int(exponent)-3*unit], supportedTimescaleUnit[-unit])
+}
+
+// Reads the header, geometry and hierarchy blocks
+// Panics when the file is not a valid FST file
+func (reader *FstReader) ParseHeader() {
+	reader.identifierNameMap = make(map[string]VcdDataType)
+	reader.blocks = nil
+	var geometry, hierarchy fstBlock
+	for offset := int64(0); ; {
+		var head [9]byte
+		if _, err := reader.data.ReadAt(head[:], offset); err != nil {
+			break
+		}
+		block := fstBlock{blockType: head[0], offset: offset + 9, length: int64(binary.BigEndian.Uint64(head[1:])) - 8}
+		switch block.blockType {
+		case fstBlockHeader:
+			reader.parseFstHeader(reader.readAt(block.offset, block.length))
+		case fstBlockValueChange, fstBlockValueChangeDynAlias, fstBlockValueChangeDynAlias2:
+			reader.blocks = append(reader.blocks, block)
+		case fstBlockGeometry:
+			geometry = block
+		case fstBlockHierarchy, fstBlockHierarchyLz4, fstBlockHierarchyLz4Duo:
+			hierarchy = block
+		case fstBlockZWrapper:
+			// The whole file is wrapped in a gzip stream
+			z, err := gzip.NewReader(io.NewSectionReader(reader.data, block.offset+8, block.length-8))
+			check(err)
+			unwrapped := check2(io.ReadAll(z)).([]byte)
+			reader.data = bytes.NewReader(unwrapped)
+			reader.ParseHeader()
+			return
+		}
+		offset = block.offset + block.length
+	}
+	if geometry.length == 0 || hierarchy.length == 0 {
+		panic("missing geometry or hierarchy block")
+	}
+	reader.parseGeometry(geometry)
+	reader.parseHierarchy(hierarchy)
+}
+
+func (reader *FstReader) parseFstHeader(header []byte) {
+	if binary.LittleEndian.Uint64(header[16:]) == math.Float64bits(fstEndianTest) {
+		reader.doubleOrder = binary.LittleEndian
+	} else {
+		reader.doubleOrder = binary.BigEndian
+	}
+	reader.Timescale = timescaleString(int8(header[64]))
+	reader.Version = strings.TrimRight(string(header[65:65+fstVersionLength]), "\x00")
+	reader.Date = strings.TrimSpace(strings.TrimRight(string(header[193:193+fstDateLength]), "\x00"))
+}
+
+// Decompresses zlib data, unless it was stored uncompressed
+func fstUncompress(data []byte, length uint64) []byte {
+	if uint64(len(data)) == length {
+		return data
+	}
+	z, err := zlib.NewReader(bytes.NewReader(data))
+	check(err)
+	return check2(io.ReadAll(z)).([]byte)
+}
+
+func (reader *FstReader) parseGeometry(block fstBlock) {
+	b := reader.readAt(block.offset, block.length)
+	length := binary.BigEndian.Uint64(b)
+	maxHandle := binary.BigEndian.Uint64(b[8:])
+	geometry := bytes.NewReader(fstUncompress(b[16:], length))
+	reader.lengths = make([]int, maxHandle+1)
+	reader.reals = make([]bool, maxHandle+1)
+	for handle := uint64(1); handle <= maxHandle; handle++ {
+		value := check2(binary.ReadUvarint(geometry)).(uint64)
+		switch value {
+		case 0:
+			reader.lengths[handle] = 8
+			reader.reals[handle] = true
+		case 0xFFFFFFFF:
+			reader.lengths[handle] = 0
+		default:
+			reader.lengths[handle] = int(value)
+		}
+	}
+}
+
+func readString(hierarchy *bytes.Reader) string {
+	var s []byte
+	for {
+		b := check2(hierarchy.ReadByte()).(byte)
+		if b == 0 {
+			return string(s)
+		}
+		s = append(s, b)
+	}
+}
+
+func (reader *FstReader) parseHierarchy(block fstBlock) {
+	if block.blockType != fstBlockHierarchy {
+		panic("LZ4 compressed hierarchies are not supported")
+	}
+	z, err := gzip.NewReader(io.NewSectionReader(reader.data, block.offset+8, block.length-8))
+	check(err)
+	hierarchy := bytes.NewReader(check2(io.ReadAll(z)).([]byte))
+	reader.aliases = make(map[int][]string)
+	var scopes []string
+	module := ""
+	handle := 0
+	for hierarchy.Len() > 0 {
+		entry := check2(hierarchy.ReadByte()).(byte)
+		switch entry {
+		case fstScope:
+			_, _ = hierarchy.ReadByte()
+			scopes = append(scopes, readString(hierarchy))
+			module = strings.Join(scopes, ".") + "."
+			readString(hierarchy)
+		case fstUpscope:
+			scopes = scopes[:len(scopes)-1]
+			module = strings.Join(scopes, ".") + "."
+			if len(scopes) == 0 {
+				module = ""
+			}
+		case fstAttrBegin:
+			attributeType := check2(hierarchy.ReadByte()).(byte)
+			subType := check2(hierarchy.ReadByte()).(byte)
+			name := readString(hierarchy)
+			check2(binary.ReadUvarint(hierarchy))
+			if attributeType == 0 && subType == 0 {
+				reader.Comment += name + " "
+			}
+		case fstAttrEnd:
+		default:
+			_, _ = hierarchy.ReadByte() // Direction
+			name := readString(hierarchy)
+			length := check2(binary.ReadUvarint(hierarchy)).(uint64)
+			alias := int(check2(binary.ReadUvarint(hierarchy)).(uint64))
+			identifier := fstIdentifier(alias)
+			if alias == 0 {
+				handle++
+				identifier = fstIdentifier(handle)
+			} else {
+				// An alias shares the values of an earlier handle, but needs an identifier of its own
+				identifier = fmt.Sprintf("%s %d", identifier, len(reader.aliases[alias])+1)
+				reader.aliases[alias] = append(reader.aliases[alias], identifier)
+			}
+			// Variables get the type name of the VCD declaration, such as reg or integer
+			// All real types are read as real, as their values are decoded to float64
+			var datType VcdDataType
+			datType.VariableType = "wire"
+			datType.BitDepth = int(length)
+			datType.identifier = identifier
+			datType.VariableName = module + strings.Split(name, " ")[0]
+			if int(entry) < len(fstVarTypeNames) {
+				datType.VariableType = fstVarTypeNames[entry]
+				switch datType.VariableType {
+				case "real", "real_parameter", "realtime", "shortreal":
+					datType.VariableType = "real"
+					datType.BitDepth = 64
+				}
+			}
+			reader.identifierNameMap[datType.identifier] = datType
+		}
+	}
+}
+
+// Converts the bytes stored by FST to the value ReadAll and Next return
+func (reader *FstReader) decodeValue(handle int, value []byte) interface{} {
+	if reader.reals[handle] {
+		return math.Float64frombits(reader.doubleOrder.Uint64(value))
+	}
+	return string(value)
+}
+
+// Decodes all value changes of a value change block into events
+func (reader *FstReader) readBlock(block fstBlock) {
+	b := reader.readAt(block.offset, block.length)
+	r := bytes.NewReader(b[24:])
+	reader.events = reader.events[:0]
+	reader.nextEvent = 0
+
+	// The time table is stored at the end of the block
+	end := len(b)
+	timeCount := binary.BigEndian.Uint64(b[end-8:])
+	timeCompressed := binary.BigEndian.Uint64(b[end-16:])
+	timeLength := binary.BigEndian.Uint64(b[end-24:])
+	timeStart := end - 24 - int(timeCompressed)
+	timeTable := bytes.NewReader(fstUncompress(b[timeStart:end-24], timeLength))
+	reader.times = reader.times[:0]
+	t := uint64(0)
+	for i := uint64(0); i < timeCount; i++ {
+		t += check2(binary.ReadUvarint(timeTable)).(uint64)
+		reader.times = append(reader.times, t)
+	}
+
+	frameLength := check2(binary.ReadUvarint(r)).(uint64)
+	frameCompressed := check2(binary.ReadUvarint(r)).(uint64)
+	check2(binary.ReadUvarint(r))
+	frameStart := len(b) - r.Len()
+	if reader.nextBlock == 0 {
+		// The frame of the first block holds the initial values
+		frame := fstUncompress(b[frameStart:frameStart+int(frameCompressed)], frameLength)
+		position := 0
+		reader.times = append([]uint64{binary.BigEndian.Uint64(b)}, reader.times...)
+		for handle := 1; handle < len(reader.lengths); handle++ {
+			length := reader.lengths[handle]
+			if length > 0 && position+length <= len(frame) {
+				reader.events = append(reader.events, fstEvent{-1, handle, reader.decodeValue(handle, frame[position:position+length])})
+			}
+			position += length
+		}
+	}
+	r = bytes.NewReader(b[frameStart+int(frameCompressed):])
+	check2(binary.ReadUvarint(r))
+	packStart := len(b) - r.Len()
+	packType := b[packStart]
+
+	// Chain positions are relative to the pack type
+	indexLength := binary.BigEndian.Uint64(b[timeStart-8:])
+	indexStart := timeStart - 8 - int(indexLength)
+	indexBytes := b[indexStart : timeStart-8]
+	index := bytes.NewReader(indexBytes)
+	positions := make([]int, len(reader.lengths))
+	aliases := make([]int, len(reader.lengths))
+	handle, position, previousAlias := 1, 0, 0
+	for index.Len() > 0 && handle < len(positions) {
+		if block.blockType == fstBlockValueChangeDynAlias2 && indexBytes[len(indexBytes)-index.Len()]&1 == 1 {
+			value := readSignedVarint(index) >> 1
+			if value > 0 {
+				position += int(value)
+				positions[handle] = position
+			} else {
+				if value < 0 {
+					previousAlias = int(-value)
+				}
+				aliases[handle] = previousAlias
+			}
+			handle++
+			continue
+		}
+		value := check2(binary.ReadUvarint(index)).(uint64)
+		if value&1 == 1 {
+			position += int(value >> 1)
+			positions[handle] = position
+			handle++
+		} else if value>>1 > 0 {
+			handle += int(value >> 1)
+		} else {
+			aliases[handle] = int(check2(binary.ReadUvarint(index)).(uint64))
+			handle++
+		}
+	}
+
+	chainEnd := func(handle int) int {
+		for next := handle + 1; next < len(positions); next++ {
+			if positions[next] != 0 {
+				return packStart + positions[next]
+			}
+		}
+		return indexStart
+	}
+	for handle := 1; handle < len(positions); handle++ {
+		source := handle
+		if aliases[handle] != 0 {
+			source = aliases[handle]
+		}
+		if positions[source] == 0 {
+			continue
+		}
+		chain := b[packStart+positions[source] : chainEnd(source)]
+		c := bytes.NewReader(chain)
+		length := check2(binary.ReadUvarint(c)).(uint64)
+		if length != 0 {
+			if packType != 'Z' && packType != '!' {
+				panic(fmt.Sprintf("unsupported FST compression: %c", packType))
+			}
+			c = bytes.NewReader(fstUncompress(chain[len(chain)-c.Len():], length))
+		}
+		reader.decodeChain(handle, c)
+	}
+	sort.SliceStable(reader.events, func(i, j int) bool {
+		return reader.events[i].timeIndex < reader.events[j].timeIndex
+	})
+	if reader.nextBlock == 0 {
+		for i := range reader.events {
+			reader.events[i].timeIndex++
+		}
+	}
+}
+
+// Reads a sign extended varint, as opposed to the zigzag encoding of encoding/binary
+func readSignedVarint(r *bytes.Reader) int64 {
+	value, shift := int64(0), uint(0)
+	for {
+		b := check2(r.ReadByte()).(byte)
+		value |= int64(b&0x7f) << shift
+		shift += 7
+		if b&0x80 == 0 {
+			if shift < 64 && b&0x40 != 0 {
+				value |= -1 << shift
+			}
+			return value
+		}
+	}
+}
+
+func (reader *FstReader) decodeChain(handle int, chain *bytes.Reader) {
+	length := reader.lengths[handle]
+	timeIndex := 0
+	for chain.Len() > 0 {
+		code := check2(binary.ReadUvarint(chain)).(uint64)
+		var value []byte
+		switch {
+		case length == 0:
+			timeIndex += int(code)
+			value = make([]byte, check2(binary.ReadUvarint(chain)).(uint64))
+			check2(io.ReadFull(chain, value))
+		case length == 1:
+			if code&1 == 0 {
+				timeIndex += int(code >> 2)
+				value = []byte{byte('0' + (code>>1)&1)}
+			} else {
+				timeIndex += int(code >> 4)
+				value = []byte{fstScalarStates[(code>>1)&7]}
+			}
+		case code&1 == 0 && !reader.reals[handle]:
+			// Packed binary value, most significant bit first
+			timeIndex += int(code >> 1)
+			packed := make([]byte, (length+7)/8)
+			check2(io.ReadFull(chain, packed))
+			value = make([]byte, length)
+			for i := range value {
+				value[i] = '0' + (packed[i/8]>>(7-i%8))&1
+			}
+		default:
+			timeIndex += int(code >> 1)
+			value = make([]byte, length)
+			check2(io.ReadFull(chain, value))
+		}
+		reader.events = append(reader.events, fstEvent{timeIndex, handle, reader.decodeValue(handle, value)})
+	}
+}
+
+// return: Completed, Time, Identifier, Value
+func (reader *FstReader) Next() (bool, int64, string, interface{}) {
+	if len(reader.pending) > 0 {
+		// The aliases of the previous event change with it
+		event := reader.events[reader.nextEvent-1]
+		identifier := reader.pending[0]
+		reader.pending = reader.pending[1:]
+		return true, reader.eventTime(event), identifier, event.value
+	}
+	for reader.nextEvent >= len(reader.events) {
+		if reader.nextBlock >= len(reader.blocks) {
+			return false, 0, "", ""
+		}
+		reader.readBlock(reader.blocks[reader.nextBlock])
+		reader.nextBlock++
+	}
+	event := reader.events[reader.nextEvent]
+	reader.nextEvent++
+	reader.pending = reader.aliases[event.handle]
+	return true, reader.eventTime(event), fstIdentifier(event.handle), event.value
+}
+
+func (reader *FstReader) eventTime(event fstEvent) int64 {
+	return int64(reader.times[event.timeIndex])
+}
+
+func (reader *FstReader) ReadAll() map[string][]ReadValue {
+	if reader.identifierNameMap == nil {
+		reader.ParseHeader()
+	}
+	return readAllValues(reader.identifierNameMap, reader.Next)
+}
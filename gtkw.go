@@ -1,7 +1,9 @@
 package vcd
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
@@ -49,57 +51,256 @@ func init() {
 	}
 }
 
+// Writer for GTKWave save files
+// The layout is buffered and rendered on Close, with the header settings in the order GTKWave writes them
 type Gtkw struct {
-	file *os.File
+	writer io.Writer
+	file   *os.File // File opened by CreateGtkw, closed by Close
+	layout GtkwSave
+	closed bool
 }
 
 type GtkMarshal interface {
 	toString() string
 	getFlags() string
+	validate() error
 }
 
 type GtkwTrace struct {
 	GtkMarshal
-	name  string
-	alias string
-	flags [] string
-}
-
-func getEncodedFlags(flags [] string) string {
-	tempFlag := uint32(0)
-	for _, flag := range flags {
-		tempFlag |= flagDecoder[flag]
-	}
-	return fmt.Sprintf("%x", tempFlag)
+	name         string
+	alias        string
+	flags        GtkwFlag
+	color        GtkwColor
+	analogHeight int
+	blankLines   int
+	filter       string // Translate filter file or process, see WithFilter and WithProcess
+	process      bool
+	err          error // Unknown flag names passed to Trace
 }
 
 func (trace GtkwTrace) getFlags() string {
-	return fmt.Sprintf("@%s\n", getEncodedFlags(trace.flags))
+	return fmt.Sprintf("@%x\n", uint32(trace.flags))
 }
 
 func (trace GtkwTrace) toString() string {
-	return fmt.Sprintf("+{%s} %s\n", trace.alias, trace.name)
+	line := fmt.Sprintf("+{%s} %s\n", trace.alias, trace.name)
+	if trace.process {
+		line = fmt.Sprintf("^>1 %s\n", trace.filter) + line
+	} else if trace.filter != "" {
+		line = fmt.Sprintf("^1 %s\n", trace.filter) + line
+	}
+	if trace.color != ColorNormal {
+		line = fmt.Sprintf("[color] %d\n", trace.color) + line
+	}
+	// Analog traces are made higher with stretch rows below them
+	if trace.analogHeight > 1 {
+		line += fmt.Sprintf("@%x\n", uint32(FlagAnalogBlankStretch|FlagBlank)) + strings.Repeat("-\n", trace.analogHeight-1)
+	}
+	if trace.blankLines > 0 {
+		line += fmt.Sprintf("@%x\n", uint32(FlagBlank)) + strings.Repeat("-\n", trace.blankLines)
+	}
+	return line
 }
 
+func (trace GtkwTrace) validate() error {
+	if trace.name == "" || strings.ContainsAny(trace.name+trace.alias+trace.filter, "\r\n") {
+		return fmt.Errorf("invalid trace name \"%s\"", trace.name)
+	}
+	if trace.err != nil {
+		return fmt.Errorf("trace %s: %w", trace.name, trace.err)
+	}
+	if err := trace.flags.Validate(); err != nil {
+		return fmt.Errorf("trace %s: %w", trace.name, err)
+	}
+	return nil
+}
+
+// Creates a trace from flag names, see flagNames
+// Unknown flag names are returned as an error by Gtkw.Trace, the known flags are kept
 func Trace(name string, alias string, flags ...string) GtkwTrace {
-	return GtkwTrace{
-		name:  name,
-		alias: alias,
-		flags: flags,
+	trace := GtkwTrace{name: name, alias: alias}
+	var unknown []string
+	for _, flag := range flags {
+		if value, ok := flagDecoder[flag]; ok {
+			trace.flags |= GtkwFlag(value)
+		} else {
+			unknown = append(unknown, flag)
+		}
+	}
+	if len(unknown) > 0 {
+		trace.err = fmt.Errorf("unknown flags %q\nUse one of the following: %v", unknown, flagNames)
 	}
+	return trace
 }
 
-func NewGtkw(filename string) Gtkw {
+// Creates a trace from typed flags such as FlagHex|FlagRjustify
+// Returns an error when mutually exclusive flags are combined
+func NewTrace(name string, alias string, flags GtkwFlag) (GtkwTrace, error) {
+	if err := flags.Validate(); err != nil {
+		return GtkwTrace{}, err
+	}
+	return GtkwTrace{name: name, alias: alias, flags: flags}, nil
+}
+
+// Returns a copy of the trace shown in the given color
+func (trace GtkwTrace) WithColor(color GtkwColor) GtkwTrace {
+	trace.color = color
+	return trace
+}
+
+// Returns a copy of the trace which is rows rows high, for analog traces
+func (trace GtkwTrace) WithAnalogHeight(rows int) GtkwTrace {
+	trace.analogHeight = rows
+	return trace
+}
+
+// Returns a copy of the trace followed by blank separator rows
+func (trace GtkwTrace) WithBlankLines(rows int) GtkwTrace {
+	trace.blankLines = rows
+	return trace
+}
+
+// Creates a writer which renders the save file to w on Close
+func NewGtkwWriter(w io.Writer) Gtkw {
+	return Gtkw{writer: w, layout: GtkwSave{trailingNewline: true}}
+}
+
+// Creates the save file, .gtkw is appended to filename when missing
+func CreateGtkw(filename string) (Gtkw, error) {
 	if !strings.HasSuffix(filename, ".gtkw") {
 		filename = filename + ".gtkw"
 	}
 	f, err := os.Create(filename)
+	if err != nil {
+		return Gtkw{}, err
+	}
+	return Gtkw{writer: f, file: f, layout: GtkwSave{trailingNewline: true}}, nil
+}
+
+// Creates the save file, see CreateGtkw
+// Panics when the file cannot be created
+func NewGtkw(filename string) Gtkw {
+	gtkw, err := CreateGtkw(filename)
 	check(err)
-	return Gtkw{file: f}
+	return gtkw
 }
 
-func (gtkw *Gtkw) SetDumpfile(dumpfile string) {
-	_, _ = gtkw.file.WriteString(fmt.Sprintf("[dumpfile] \"%s\"\n", filepath.Base(dumpfile)))
+var errGtkwClosed = errors.New("gtkw: layout is already closed")
+
+func (gtkw *Gtkw) SetDumpfile(dumpfile string) error {
+	if dumpfile == "" {
+		return errors.New("gtkw: empty dump file")
+	}
+	return gtkw.setting("dumpfile", fmt.Sprintf("\"%s\"", filepath.Base(dumpfile)))
+}
+
+// Sets the time shown at the left edge of the wave window
+func (gtkw *Gtkw) SetTimestart(time uint64) error {
+	return gtkw.setting("timestart", fmt.Sprintf("%d", time))
+}
+
+// Named marker, see SetZoom
+type GtkwMarker struct {
+	Time int64
+	Name string
+}
+
+// Maximum number of named markers, A to Z
+const maxMarkers = 26
+
+// Sets the zoom level, primary marker and named markers A to Z
+// Zoom is the level GTKWave stores, 0 shows one time unit per pixel and every -1 halves the scale
+// A marker time of -1 leaves the marker unset
+func (gtkw *Gtkw) SetZoom(zoom float64, primaryMarker int64, markers ...GtkwMarker) error {
+	if gtkw.closed {
+		return errGtkwClosed
+	}
+	if len(markers) > maxMarkers {
+		return fmt.Errorf("too many markers: %d, at most %d are supported", len(markers), maxMarkers)
+	}
+	line := &GtkwZoom{Zoom: zoom, Primary: primaryMarker}
+	for i := 0; i < maxMarkers; i++ {
+		if i < len(markers) {
+			line.Markers = append(line.Markers, markers[i].Time)
+		} else {
+			line.Markers = append(line.Markers, -1)
+		}
+	}
+	gtkw.layout.removeHeader(func(l GtkwLine) bool {
+		setting, ok := l.(*GtkwSetting)
+		_, zoom := l.(*GtkwZoom)
+		return zoom || ok && setting.Key == "markername"
+	})
+	gtkw.layout.insertHeader(line)
+	for i, marker := range markers {
+		if marker.Name != "" && marker.Time >= 0 {
+			gtkw.layout.insertHeader(&GtkwSetting{Key: "markername", Value: fmt.Sprintf("%c%s", 'A'+i, marker.Name)})
+		}
+	}
+	return nil
+}
+
+// Sets the size of the GTKWave window in pixels
+func (gtkw *Gtkw) SetSize(width int, height int) error {
+	if width <= 0 || height <= 0 {
+		return fmt.Errorf("gtkw: invalid window size %dx%d", width, height)
+	}
+	return gtkw.setting("size", fmt.Sprintf("%d %d", width, height))
+}
+
+// Sets the position of the GTKWave window, -1 -1 lets the window manager decide
+func (gtkw *Gtkw) SetPos(x int, y int) error {
+	if x < -1 || y < -1 {
+		return fmt.Errorf("gtkw: invalid window position %d %d", x, y)
+	}
+	return gtkw.setting("pos", fmt.Sprintf("%d %d", x, y))
+}
+
+// Expands scopes in the hierarchy tree, such as example.logic
+func (gtkw *Gtkw) TreeOpen(scopes ...string) error {
+	if gtkw.closed {
+		return errGtkwClosed
+	}
+	for _, scope := range scopes {
+		if scope == "" || strings.ContainsAny(scope, "\r\n") {
+			return fmt.Errorf("gtkw: invalid scope \"%s\"", scope)
+		}
+		if !strings.HasSuffix(scope, ".") {
+			scope += "."
+		}
+		gtkw.layout.removeHeader(func(l GtkwLine) bool {
+			setting, ok := l.(*GtkwSetting)
+			return ok && setting.Key == "treeopen" && setting.Value == scope
+		})
+		gtkw.layout.insertHeader(&GtkwSetting{Key: "treeopen", Value: scope})
+	}
+	return nil
+}
+
+// Sets the width of the hierarchy tree in pixels
+func (gtkw *Gtkw) SetSstWidth(width int) error {
+	if width < 0 {
+		return fmt.Errorf("gtkw: invalid hierarchy width %d", width)
+	}
+	return gtkw.setting("sst_width", fmt.Sprintf("%d", width))
+}
+
+// Sets the width of the signal name column in pixels
+func (gtkw *Gtkw) SetSignalsWidth(width int) error {
+	if width < 0 {
+		return fmt.Errorf("gtkw: invalid signal column width %d", width)
+	}
+	return gtkw.setting("signals_width", fmt.Sprintf("%d", width))
+}
+
+// Sets a header setting, replacing an earlier value
+func (gtkw *Gtkw) setting(key string, value string) error {
+	if gtkw.closed {
+		return errGtkwClosed
+	}
+	gtkw.layout.SetSetting(key, value)
+	return nil
 }
 
 func (gtkw *Gtkw) writeFlags(flags ...string) {
@@ -107,47 +308,87 @@ func (gtkw *Gtkw) writeFlags(flags ...string) {
 	for _, flag := range flags {
 		tempFlag |= flagDecoder[flag]
 	}
-	_, _ = gtkw.file.WriteString(fmt.Sprintf("@%x\n", tempFlag))
+	gtkw.write(fmt.Sprintf("@%x\n", tempFlag))
+}
+
+// Adds a group of traces, which can contain nested groups, comments and blank rows
+func (gtkw *Gtkw) Group(groupName string, closed bool, traces ...GtkMarshal) error {
+	return gtkw.writeTraces([]GtkMarshal{Group(groupName, closed, traces...)})
 }
 
-func (gtkw *Gtkw) Group(groupName string, closed bool, traces ...GtkMarshal) {
-	// Start the group
-	if closed {
-		gtkw.writeFlags("grp_begin", "closed", "blank")
-	} else {
-		gtkw.writeFlags("grp_begin", "blank")
+// Adds traces, groups, comments and blank rows
+// Returns an error without adding anything when one of them is invalid
+func (gtkw *Gtkw) Trace(traces ...GtkMarshal) error {
+	return gtkw.writeTraces(traces)
+}
+
+// Writes traces, only writing their flags when they differ from the previous trace
+func (gtkw *Gtkw) writeTraces(traces []GtkMarshal) error {
+	if gtkw.closed {
+		return errGtkwClosed
 	}
-	_, _ = gtkw.file.WriteString(fmt.Sprintf("-%s\n", groupName))
-	prevFlag := ""
 	for _, trace := range traces {
-		flag := trace.getFlags()
-		if flag != prevFlag {
-			_, _ = gtkw.file.WriteString(flag)
-			prevFlag = flag
+		if err := trace.validate(); err != nil {
+			return err
 		}
-		_, _ = gtkw.file.WriteString(trace.toString())
 	}
-	// End the group
-	if closed {
-		gtkw.writeFlags("grp_end", "closed", "blank", "collapsed")
-	} else {
-		gtkw.writeFlags("grp_end", "blank")
-	}
-	_, _ = gtkw.file.WriteString(fmt.Sprintf("-%s\n", groupName))
+	gtkw.write(renderTraces(traces))
+	return nil
 }
 
-func (gtkw *Gtkw) Trace(traces ...GtkMarshal) {
+// Renders traces, only writing their flags when they differ from the previous trace
+func renderTraces(traces []GtkMarshal) string {
+	var b strings.Builder
 	prevFlag := ""
 	for _, trace := range traces {
 		flag := trace.getFlags()
 		if flag != prevFlag {
-			_, _ = gtkw.file.WriteString(flag)
+			b.WriteString(flag)
 			prevFlag = flag
 		}
-		_, _ = gtkw.file.WriteString(trace.toString())
+		lines := trace.toString()
+		b.WriteString(lines)
+		// Traces can change the flags for rows they add, such as blank lines or group contents
+		if i := strings.LastIndex("\n"+lines, "\n@"); i >= 0 {
+			prevFlag = lines[i : i+strings.Index(lines[i:], "\n")+1]
+		}
 	}
+	return b.String()
 }
 
-func (gtkw *Gtkw) Close() {
-	_ = gtkw.file.Close()
+// Adds lines to the layout
+func (gtkw *Gtkw) write(lines string) {
+	if lines == "" {
+		return
+	}
+	for _, line := range strings.Split(strings.TrimSuffix(lines, "\n"), "\n") {
+		gtkw.layout.Lines = append(gtkw.layout.Lines, parseGtkwLine(line))
+	}
+	gtkw.layout.trailingNewline = true
+}
+
+// Returns the save file as it is rendered on Close
+func (gtkw *Gtkw) String() string {
+	return gtkw.layout.String()
+}
+
+// Writes the save file to w, for example to keep a copy next to the one written on Close
+func (gtkw *Gtkw) WriteTo(w io.Writer) (int64, error) {
+	return gtkw.layout.WriteTo(w)
+}
+
+// Renders the save file and closes the file opened by CreateGtkw
+// Layout methods return an error after Close
+func (gtkw *Gtkw) Close() error {
+	if gtkw.closed {
+		return errGtkwClosed
+	}
+	gtkw.closed = true
+	_, err := gtkw.layout.WriteTo(gtkw.writer)
+	if gtkw.file != nil {
+		if closeErr := gtkw.file.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
 }
@@ -0,0 +1,117 @@
+package vcd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Value of an enum signal and the label GTKWave shows for it
+type EnumValue struct {
+	Value uint64
+	Label string
+	Color string // X11 color name such as red or DarkBlue, empty for the trace color
+}
+
+// Mapping from the values of a signal to labels, written as a GTKWave translate filter file
+type GtkwEnum struct {
+	bitDepth int
+	values   []EnumValue
+}
+
+// Creates an enum for a signal of bitDepth bits
+// Returns an error when a value does not fit or is mapped twice, or a label is empty or spans lines
+func NewEnum(bitDepth int, values ...EnumValue) (GtkwEnum, error) {
+	seen := make(map[uint64]bool)
+	for _, value := range values {
+		if bitDepth < 64 && value.Value >= 1<<bitDepth {
+			return GtkwEnum{}, fmt.Errorf("enum value %d of %s does not fit in %d bits", value.Value, value.Label, bitDepth)
+		}
+		if seen[value.Value] {
+			return GtkwEnum{}, fmt.Errorf("enum value %d is mapped twice", value.Value)
+		}
+		if strings.TrimSpace(value.Label) == "" || strings.ContainsAny(value.Label+value.Color, "\r\n") {
+			return GtkwEnum{}, fmt.Errorf("invalid label \"%s\" for enum value %d", value.Label, value.Value)
+		}
+		if strings.ContainsAny(value.Color, "? ") {
+			return GtkwEnum{}, fmt.Errorf("invalid color \"%s\" for enum value %d", value.Color, value.Value)
+		}
+		seen[value.Value] = true
+	}
+	sorted := append([]EnumValue(nil), values...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Value < sorted[j].Value })
+	return GtkwEnum{bitDepth: bitDepth, values: sorted}, nil
+}
+
+// Returns the values of the enum, ordered by value
+func (enum GtkwEnum) Values() []EnumValue {
+	return append([]EnumValue(nil), enum.values...)
+}
+
+// Returns the label of a value, or false when the value is not mapped
+func (enum GtkwEnum) Label(value uint64) (string, bool) {
+	for _, v := range enum.values {
+		if v.Value == value {
+			return v.Label, true
+		}
+	}
+	return "", false
+}
+
+// Writes the filter file, one line per value with the value in hex as GTKWave displays it
+func (enum GtkwEnum) WriteFilter(w io.Writer) error {
+	digits := (enum.bitDepth + 3) / 4
+	for _, value := range enum.values {
+		label := value.Label
+		if value.Color != "" {
+			label = fmt.Sprintf("?%s?%s", value.Color, label)
+		}
+		if _, err := fmt.Fprintf(w, "%0*X %s\n", digits, value.Value, label); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Writes the filter file with the given name
+func (enum GtkwEnum) WriteFilterFile(filename string) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if err = enum.WriteFilter(f); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Returns a copy of the trace translated with the filter file at path
+// GTKWave finds filters by path, so traces sharing a filter file share its translations
+func (trace GtkwTrace) WithFilter(path string) GtkwTrace {
+	trace.flags = trace.flags&^FlagPtranslated | FlagFtranslated
+	trace.filter, trace.process = path, false
+	return trace
+}
+
+// Returns a copy of the trace translated by the filter process at path, see the procfilter package
+func (trace GtkwTrace) WithProcess(path string) GtkwTrace {
+	trace.flags = trace.flags&^FlagFtranslated | FlagPtranslated
+	trace.filter, trace.process = path, true
+	return trace
+}
+
+// Writes the filter file for an enum variable and returns a hex trace translated with it
+func EnumTrace(variable VcdDataType, filterFile string, enum GtkwEnum) (GtkwTrace, error) {
+	if err := enum.WriteFilterFile(filterFile); err != nil {
+		return GtkwTrace{}, err
+	}
+	_, alias := splitScope(variable.VariableName)
+	trace, err := NewTrace(TraceName(variable), alias, FlagHex)
+	if err != nil {
+		return GtkwTrace{}, err
+	}
+	return trace.WithFilter(filterFile), nil
+}
@@ -0,0 +1,92 @@
+package vcd
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Display flags of a trace, combined into a bitmask with |
+// The order matches flagNames
+type GtkwFlag uint32
+
+const (
+	FlagHighlight GtkwFlag = 1 << iota
+	FlagHex
+	FlagDec
+	FlagBin
+	FlagOct
+	FlagRjustify
+	FlagInvert
+	FlagReverse
+	FlagExclude
+	FlagBlank
+	FlagSigned
+	FlagAscii
+	FlagCollapsed
+	FlagFtranslated
+	FlagPtranslated
+	FlagAnalogStep
+	FlagAnalogInterpolated
+	FlagAnalogBlankStretch
+	FlagReal
+	FlagAnalogFullscale
+	FlagZerofill
+	FlagOnefill
+	FlagClosed
+	FlagGrpBegin
+	FlagGrpEnd
+	FlagBinGray
+	FlagGrayBin
+	FlagReal2Bits
+	FlagTtranslated
+	FlagPopcnt
+	FlagFpdecshift
+)
+
+// Flags of which at most one can be set
+var displayFormatFlags = FlagHex | FlagDec | FlagBin | FlagOct | FlagSigned | FlagAscii | FlagReal | FlagReal2Bits
+
+// Converts flag names to a bitmask, see flagNames
+func ParseFlags(names ...string) (GtkwFlag, error) {
+	flags := GtkwFlag(0)
+	for _, name := range names {
+		flag, ok := flagDecoder[name]
+		if !ok {
+			return 0, fmt.Errorf("unknown flag: \"%s\"\nUse one of the following: %v", name, flagNames)
+		}
+		flags |= GtkwFlag(flag)
+	}
+	return flags, nil
+}
+
+// Returns the names of the set flags, see flagNames
+func (flags GtkwFlag) Names() []string {
+	return GtkwFlags{Flags: uint32(flags)}.Names()
+}
+
+func (flags GtkwFlag) String() string {
+	return strings.Join(flags.Names(), "|")
+}
+
+// Returns an error when mutually exclusive flags are combined, such as hex and dec
+// Analog step and interpolated may be combined, GTKWave shows that as interpolated annotated
+func (flags GtkwFlag) Validate() error {
+	if formats := flags & displayFormatFlags; formats&(formats-1) != 0 {
+		return fmt.Errorf("conflicting display formats: %s", formats)
+	}
+	return nil
+}
+
+// Trace colors of GTKWave
+type GtkwColor int
+
+const (
+	ColorNormal GtkwColor = iota
+	ColorRed
+	ColorOrange
+	ColorYellow
+	ColorGreen
+	ColorBlue
+	ColorIndigo
+	ColorViolet
+)
@@ -0,0 +1,122 @@
+package vcd
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Splits a full variable path such as example.logic.mosi into its scope and name
+func splitScope(path string) (string, string) {
+	if i := strings.LastIndex(path, "."); i >= 0 {
+		return path[:i], path[i+1:]
+	}
+	return "", path
+}
+
+// Returns the GTKWave trace name of a variable, buses get their bit range such as example.logic.mosi[7:0]
+func TraceName(variable VcdDataType) string {
+	switch variable.VariableType {
+	case "real", "string":
+		return variable.VariableName
+	}
+	if variable.BitDepth > 1 {
+		return fmt.Sprintf("%s[%d:0]", variable.VariableName, variable.BitDepth-1)
+	}
+	return variable.VariableName
+}
+
+// Returns a trace for a variable with default flags for its type
+// Buses are shown in hex, single bits in binary and reals as interpolated analog
+func TraceFor(variable VcdDataType) GtkwTrace {
+	_, alias := splitScope(variable.VariableName)
+	switch {
+	case variable.VariableType == "real":
+		return Trace(TraceName(variable), alias, "analog_interpolated", "analog_fullscale")
+	case variable.VariableType == "string":
+		return Trace(TraceName(variable), alias)
+	case variable.BitDepth > 1:
+		return Trace(TraceName(variable), alias, "rjustify", "hex")
+	}
+	return Trace(TraceName(variable), alias, "bin")
+}
+
+// Scope of a layout with its traces and the scopes below it, in order of first appearance
+type layoutScope struct {
+	name     string
+	traces   []GtkMarshal
+	children []*layoutScope
+}
+
+// Returns the scope below scope with the given name, adding it when it does not exist yet
+func (scope *layoutScope) child(name string) *layoutScope {
+	for _, child := range scope.children {
+		if child.name == name {
+			return child
+		}
+	}
+	child := &layoutScope{name: name}
+	scope.children = append(scope.children, child)
+	return child
+}
+
+// Returns a group for every scope below scope, followed by the traces of scope itself
+func (scope *layoutScope) items(closed bool) []GtkMarshal {
+	var items []GtkMarshal
+	for _, child := range scope.children {
+		items = append(items, Group(child.name, closed, child.items(closed)...))
+	}
+	return append(items, scope.traces...)
+}
+
+// Adds nested groups mirroring the scopes, holding a trace for each of their variables
+// Variables are expected with their full path, as returned by the Variables methods of writers and readers
+// Within a group the groups of sub scopes come first, variables without a scope are added after all groups
+func (gtkw *Gtkw) Layout(variables []VcdDataType, closed bool) error {
+	root := &layoutScope{}
+	for _, variable := range variables {
+		scope := root
+		if path, _ := splitScope(variable.VariableName); path != "" {
+			for _, name := range strings.Split(path, ".") {
+				scope = scope.child(name)
+			}
+		}
+		scope.traces = append(scope.traces, TraceFor(variable))
+	}
+	return gtkw.Trace(root.items(closed)...)
+}
+
+// Returns every scope of the variables and the scopes above them, such as example and example.logic
+func scopePaths(variables []VcdDataType) []string {
+	var paths []string
+	for _, variable := range variables {
+		path, _ := splitScope(variable.VariableName)
+		for path != "" {
+			if !stringInSlice(path, paths) {
+				paths = append(paths, path)
+			}
+			path, _ = splitScope(path)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// Creates a save file for dumpfile showing all variables, grouped by scope with all scopes expanded
+func GenerateGtkw(filename string, dumpfile string, variables []VcdDataType) error {
+	gtkw, err := CreateGtkw(filename)
+	if err != nil {
+		return err
+	}
+	if err = gtkw.SetDumpfile(dumpfile); err != nil {
+		_ = gtkw.Close()
+		return err
+	}
+	if err = gtkw.TreeOpen(scopePaths(variables)...); err == nil {
+		err = gtkw.Layout(variables, false)
+	}
+	if closeErr := gtkw.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,463 @@
+package vcd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Parsed .gtkw save file
+// Every line is kept in order, unchanged lines are written back exactly as they were read
+type GtkwSave struct {
+	Lines           []GtkwLine
+	trailingNewline bool
+}
+
+// A line of a save file, one of the Gtkw* line types
+type GtkwLine interface {
+	canonical() string
+	source() *gtkwSource
+}
+
+// The text a line was parsed from, used to write unchanged lines back as they were
+type gtkwSource struct {
+	raw   string
+	canon string
+}
+
+func (s *gtkwSource) source() *gtkwSource {
+	return s
+}
+
+// [*] comment line
+type GtkwComment struct {
+	gtkwSource
+	Text string
+}
+
+// [key] value line, such as [dumpfile] "example.vcd" or [size] 1000 600
+type GtkwSetting struct {
+	gtkwSource
+	Key   string
+	Value string
+}
+
+// *zoom primary markers... line, unset markers are -1
+type GtkwZoom struct {
+	gtkwSource
+	Zoom    float64
+	Primary int64
+	Markers []int64
+}
+
+// @flags line, applies to the traces that follow
+type GtkwFlags struct {
+	gtkwSource
+	Flags uint32
+}
+
+// +{alias} name line, or a plain name without alias
+type GtkwTraceLine struct {
+	gtkwSource
+	Alias string
+	Name  string
+}
+
+// #{name} bits... line, a vector concatenated from bits of other signals
+type GtkwVectorLine struct {
+	gtkwSource
+	Name string
+	Bits []string
+}
+
+// -text line, used for comments, blank lines and group names
+type GtkwLabel struct {
+	gtkwSource
+	Text string
+}
+
+// ^index path line for filter files, ^>index path for filter processes
+type GtkwFilter struct {
+	gtkwSource
+	Process bool
+	Index   int
+	Path    string
+}
+
+// Any other line
+type GtkwRaw struct {
+	gtkwSource
+	Text string
+}
+
+func (l *GtkwComment) canonical() string {
+	return "[*]" + l.Text
+}
+
+func (l *GtkwSetting) canonical() string {
+	if l.Value == "" {
+		return "[" + l.Key + "]"
+	}
+	return "[" + l.Key + "] " + l.Value
+}
+
+func (l *GtkwZoom) canonical() string {
+	line := fmt.Sprintf("*%f %d", l.Zoom, l.Primary)
+	for _, marker := range l.Markers {
+		line += fmt.Sprintf(" %d", marker)
+	}
+	return line
+}
+
+func (l *GtkwFlags) canonical() string {
+	return fmt.Sprintf("@%x", l.Flags)
+}
+
+func (l *GtkwTraceLine) canonical() string {
+	if l.Alias == "" {
+		return l.Name
+	}
+	return fmt.Sprintf("+{%s} %s", l.Alias, l.Name)
+}
+
+func (l *GtkwVectorLine) canonical() string {
+	return fmt.Sprintf("#{%s} %s", l.Name, strings.Join(l.Bits, " "))
+}
+
+func (l *GtkwLabel) canonical() string {
+	return "-" + l.Text
+}
+
+func (l *GtkwFilter) canonical() string {
+	if l.Process {
+		return fmt.Sprintf("^>%d %s", l.Index, l.Path)
+	}
+	return fmt.Sprintf("^%d %s", l.Index, l.Path)
+}
+
+func (l *GtkwRaw) canonical() string {
+	return l.Text
+}
+
+// Returns the names of the set flags, see flagNames
+func (l GtkwFlags) Names() []string {
+	var names []string
+	for i, name := range flagNames {
+		if l.Flags&(1<<i) != 0 {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// Returns true when the named flag is set, see flagNames
+func (l GtkwFlags) Has(name string) bool {
+	return l.Flags&flagDecoder[name] != 0
+}
+
+// Parses a single line of a save file
+func parseGtkwLine(raw string) GtkwLine {
+	text := strings.TrimRight(raw, "\r")
+	var line GtkwLine = &GtkwRaw{Text: text}
+	switch {
+	case text == "":
+	case strings.HasPrefix(text, "[*]"):
+		line = &GtkwComment{Text: text[3:]}
+	case strings.HasPrefix(text, "[") && strings.Contains(text, "]"):
+		end := strings.Index(text, "]")
+		line = &GtkwSetting{Key: text[1:end], Value: strings.TrimSpace(text[end+1:])}
+	case text[0] == '*':
+		fields := strings.Fields(text[1:])
+		zoom := &GtkwZoom{}
+		var err error
+		if len(fields) >= 2 {
+			zoom.Zoom, err = strconv.ParseFloat(fields[0], 64)
+			if err == nil {
+				zoom.Primary, err = strconv.ParseInt(fields[1], 10, 64)
+			}
+			for _, field := range fields[2:] {
+				marker, markerErr := strconv.ParseInt(field, 10, 64)
+				if markerErr != nil {
+					err = markerErr
+				}
+				zoom.Markers = append(zoom.Markers, marker)
+			}
+			if err == nil {
+				line = zoom
+			}
+		}
+	case text[0] == '@':
+		if flags, err := strconv.ParseUint(text[1:], 16, 32); err == nil {
+			line = &GtkwFlags{Flags: uint32(flags)}
+		}
+	case strings.HasPrefix(text, "+{") && strings.Contains(text, "} "):
+		end := strings.Index(text, "} ")
+		line = &GtkwTraceLine{Alias: text[2:end], Name: text[end+2:]}
+	case strings.HasPrefix(text, "#{") && strings.Contains(text, "} "):
+		end := strings.Index(text, "} ")
+		line = &GtkwVectorLine{Name: text[2:end], Bits: strings.Fields(text[end+2:])}
+	case text[0] == '-':
+		line = &GtkwLabel{Text: text[1:]}
+	case text[0] == '^':
+		filter := &GtkwFilter{}
+		rest := text[1:]
+		if strings.HasPrefix(rest, ">") {
+			filter.Process = true
+			rest = rest[1:]
+		}
+		fields := strings.SplitN(rest, " ", 2)
+		if index, err := strconv.Atoi(fields[0]); err == nil && len(fields) == 2 {
+			filter.Index, filter.Path = index, fields[1]
+			line = filter
+		}
+	case !strings.ContainsAny(text[:1], "+#!"):
+		line = &GtkwTraceLine{Name: text}
+	}
+	source := line.source()
+	source.raw = raw
+	source.canon = line.canonical()
+	return line
+}
+
+// Parses a .gtkw save file
+func ParseGtkw(r io.Reader) (*GtkwSave, error) {
+	save := &GtkwSave{}
+	buffered := bufio.NewReader(r)
+	for {
+		text, err := buffered.ReadString('\n')
+		if text != "" {
+			save.trailingNewline = strings.HasSuffix(text, "\n")
+			save.Lines = append(save.Lines, parseGtkwLine(strings.TrimSuffix(text, "\n")))
+		}
+		if err == io.EOF {
+			return save, nil
+		} else if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Parses the .gtkw save file with the given name
+func ParseGtkwFile(filename string) (*GtkwSave, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseGtkw(f)
+}
+
+func renderGtkwLine(line GtkwLine) string {
+	canonical := line.canonical()
+	if source := line.source(); source.raw != "" && source.canon == canonical {
+		return source.raw
+	}
+	return canonical
+}
+
+// Returns the save file as text, lines that were not modified are unchanged
+func (save *GtkwSave) String() string {
+	var b strings.Builder
+	for i, line := range save.Lines {
+		b.WriteString(renderGtkwLine(line))
+		if i < len(save.Lines)-1 || save.trailingNewline {
+			b.WriteString("\n")
+		}
+	}
+	return b.String()
+}
+
+// Writes the save file
+func (save *GtkwSave) WriteTo(w io.Writer) (int64, error) {
+	n, err := io.WriteString(w, save.String())
+	return int64(n), err
+}
+
+// Returns the first setting with the given key, or nil
+func (save *GtkwSave) Setting(key string) *GtkwSetting {
+	for _, line := range save.Lines {
+		if setting, ok := line.(*GtkwSetting); ok && setting.Key == key {
+			return setting
+		}
+	}
+	return nil
+}
+
+// Changes the value of a setting, adding it among the header settings when it does not exist yet
+func (save *GtkwSave) SetSetting(key string, value string) {
+	if setting := save.Setting(key); setting != nil {
+		setting.Value = value
+		return
+	}
+	save.insertHeader(&GtkwSetting{Key: key, Value: value})
+}
+
+// Order of the header lines as GTKWave writes them, the zoom line is *
+var gtkwHeaderOrder = []string{"dumpfile", "dumpfile_mtime", "dumpfile_size", "savefile", "timestart", "size", "pos",
+	"*", "markername", "treeopen", "sst_width", "signals_width", "sst_expanded", "sst_vpaned_height"}
+
+// Returns the position of a header line in gtkwHeaderOrder, comments come first and unknown settings last
+func gtkwHeaderRank(line GtkwLine) int {
+	key := ""
+	switch l := line.(type) {
+	case *GtkwComment:
+		return -1
+	case *GtkwZoom:
+		key = "*"
+	case *GtkwSetting:
+		key = l.Key
+	}
+	for i, name := range gtkwHeaderOrder {
+		if name == key {
+			return i
+		}
+	}
+	return len(gtkwHeaderOrder)
+}
+
+// Adds a header line after the header lines that GTKWave writes before it
+func (save *GtkwSave) insertHeader(line GtkwLine) {
+	rank := gtkwHeaderRank(line)
+	position := 0
+	for position < len(save.Lines) && isGtkwHeaderLine(save.Lines[position]) && gtkwHeaderRank(save.Lines[position]) <= rank {
+		position++
+	}
+	save.Lines = append(save.Lines[:position], append([]GtkwLine{line}, save.Lines[position:]...)...)
+}
+
+// Removes the header lines for which remove returns true
+func (save *GtkwSave) removeHeader(remove func(GtkwLine) bool) {
+	lines := save.Lines[:0]
+	for i, line := range save.Lines {
+		if !isGtkwHeaderLine(line) {
+			lines = append(lines, save.Lines[i:]...)
+			break
+		}
+		if !remove(line) {
+			lines = append(lines, line)
+		}
+	}
+	save.Lines = lines
+}
+
+// Header lines come before the first trace, trace colors are part of the traces
+func isGtkwHeaderLine(line GtkwLine) bool {
+	switch l := line.(type) {
+	case *GtkwComment, *GtkwZoom:
+		return true
+	case *GtkwSetting:
+		return l.Key != "color"
+	}
+	return false
+}
+
+// Returns the dump file the save file refers to
+func (save *GtkwSave) Dumpfile() string {
+	if setting := save.Setting("dumpfile"); setting != nil {
+		return strings.Trim(setting.Value, "\"")
+	}
+	return ""
+}
+
+// Changes the dump file the save file refers to
+func (save *GtkwSave) SetDumpfile(dumpfile string) {
+	save.SetSetting("dumpfile", fmt.Sprintf("\"%s\"", dumpfile))
+}
+
+// Returns the zoom and marker line, or nil
+func (save *GtkwSave) Zoom() *GtkwZoom {
+	for _, line := range save.Lines {
+		if zoom, ok := line.(*GtkwZoom); ok {
+			return zoom
+		}
+	}
+	return nil
+}
+
+// Trace of a save file with the state that applies to it
+type GtkwParsedTrace struct {
+	Name   string
+	Alias  string
+	Bits   []string // Bits of a concatenated vector
+	Flags  GtkwFlags
+	Color  GtkwColor
+	Groups []string // Names of the enclosing groups, outermost first
+	Filter *GtkwFilter
+}
+
+// Returns all traces, with the flags, color, groups and filter that apply to them
+func (save *GtkwSave) Traces() []GtkwParsedTrace {
+	var traces []GtkwParsedTrace
+	var groups []string
+	var filter *GtkwFilter
+	flags := GtkwFlags{}
+	color := ColorNormal
+	for _, line := range save.Lines {
+		switch l := line.(type) {
+		case *GtkwFlags:
+			flags = GtkwFlags{Flags: l.Flags}
+		case *GtkwSetting:
+			if l.Key == "color" {
+				index, _ := strconv.Atoi(l.Value)
+				color = GtkwColor(index)
+			}
+		case *GtkwFilter:
+			filter = l
+		case *GtkwLabel:
+			if flags.Has("grp_begin") {
+				groups = append(groups, l.Text)
+			} else if flags.Has("grp_end") && len(groups) > 0 {
+				groups = groups[:len(groups)-1]
+			}
+		case *GtkwTraceLine, *GtkwVectorLine:
+			trace := GtkwParsedTrace{Flags: flags, Color: color, Groups: append([]string(nil), groups...)}
+			if t, ok := l.(*GtkwTraceLine); ok {
+				trace.Name, trace.Alias = t.Name, t.Alias
+			} else {
+				v := l.(*GtkwVectorLine)
+				trace.Name, trace.Bits = v.Name, v.Bits
+			}
+			if flags.Has("ftranslated") || flags.Has("ptranslated") {
+				trace.Filter = filter
+			}
+			traces = append(traces, trace)
+			color = ColorNormal
+		}
+	}
+	return traces
+}
+
+// Returns the names of all groups, nested groups are joined with /
+func (save *GtkwSave) Groups() []string {
+	var names []string
+	var groups []string
+	flags := GtkwFlags{}
+	for _, line := range save.Lines {
+		switch l := line.(type) {
+		case *GtkwFlags:
+			flags = GtkwFlags{Flags: l.Flags}
+		case *GtkwLabel:
+			if flags.Has("grp_begin") {
+				groups = append(groups, l.Text)
+				names = append(names, strings.Join(groups, "/"))
+			} else if flags.Has("grp_end") && len(groups) > 0 {
+				groups = groups[:len(groups)-1]
+			}
+		}
+	}
+	return names
+}
+
+// Appends traces and groups at the end of the save file
+func (save *GtkwSave) Add(traces ...GtkMarshal) {
+	for _, trace := range traces {
+		for _, text := range strings.Split(strings.TrimSuffix(trace.getFlags()+trace.toString(), "\n"), "\n") {
+			line := parseGtkwLine(text)
+			*line.source() = gtkwSource{}
+			save.Lines = append(save.Lines, line)
+		}
+	}
+}
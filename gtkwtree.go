@@ -0,0 +1,125 @@
+package vcd
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Group of traces, which can contain nested groups, comments and blank rows
+type GtkwGroup struct {
+	GtkMarshal
+	name   string
+	closed bool
+	items  []GtkMarshal
+}
+
+// Creates a group for the layout tree, see Gtkw.Trace
+func Group(name string, closed bool, items ...GtkMarshal) GtkwGroup {
+	return GtkwGroup{name: name, closed: closed, items: items}
+}
+
+func (group GtkwGroup) getFlags() string {
+	if group.closed {
+		return fmt.Sprintf("@%x\n", uint32(FlagGrpBegin|FlagClosed|FlagBlank))
+	}
+	return fmt.Sprintf("@%x\n", uint32(FlagGrpBegin|FlagBlank))
+}
+
+func (group GtkwGroup) validate() error {
+	if group.name == "" || strings.ContainsAny(group.name, "\r\n") {
+		return fmt.Errorf("invalid group name \"%s\"", group.name)
+	}
+	for _, item := range group.items {
+		if err := item.validate(); err != nil {
+			return fmt.Errorf("group %s: %w", group.name, err)
+		}
+	}
+	return nil
+}
+
+func (group GtkwGroup) toString() string {
+	end := FlagGrpEnd | FlagBlank
+	if group.closed {
+		end |= FlagClosed | FlagCollapsed
+	}
+	return fmt.Sprintf("-%s\n", group.name) + renderTraces(group.items) + fmt.Sprintf("@%x\n-%s\n", uint32(end), group.name)
+}
+
+// Row without a signal, showing a comment or nothing at all
+type GtkwText struct {
+	GtkMarshal
+	text string
+}
+
+// Creates a comment row such as "--- bus ---"
+func Comment(text string) GtkwText {
+	return GtkwText{text: text}
+}
+
+// Creates an empty separator row
+func Blank() GtkwText {
+	return GtkwText{}
+}
+
+func (text GtkwText) getFlags() string {
+	return fmt.Sprintf("@%x\n", uint32(FlagBlank))
+}
+
+func (text GtkwText) validate() error {
+	if strings.ContainsAny(text.text, "\r\n") {
+		return fmt.Errorf("comment spans lines: \"%s\"", text.text)
+	}
+	return nil
+}
+
+func (text GtkwText) toString() string {
+	return fmt.Sprintf("-%s\n", text.text)
+}
+
+// Vector concatenated from single bits of other signals
+type GtkwVector struct {
+	GtkMarshal
+	name  string
+	flags GtkwFlag
+	bits  []string
+}
+
+// Creates a vector named name from bits such as top.a[3] top.b[0], most significant bit first
+// Returns an error when mutually exclusive flags are combined
+func Vector(name string, flags GtkwFlag, bits ...string) (GtkwVector, error) {
+	if err := flags.Validate(); err != nil {
+		return GtkwVector{}, err
+	}
+	if len(bits) == 0 {
+		return GtkwVector{}, fmt.Errorf("vector %s has no bits", name)
+	}
+	return GtkwVector{name: name, flags: flags, bits: bits}, nil
+}
+
+// Returns the bits msb down to lsb of a signal, such as top.a[3] top.a[2] for Bits("top.a", 3, 2)
+func Bits(signal string, msb int, lsb int) []string {
+	var bits []string
+	step := -1
+	if msb < lsb {
+		step = 1
+	}
+	for bit := msb; bit != lsb+step; bit += step {
+		bits = append(bits, fmt.Sprintf("%s[%d]", signal, bit))
+	}
+	return bits
+}
+
+func (vector GtkwVector) getFlags() string {
+	return fmt.Sprintf("@%x\n", uint32(vector.flags))
+}
+
+func (vector GtkwVector) validate() error {
+	if vector.name == "" || len(vector.bits) == 0 || strings.ContainsAny(vector.name+strings.Join(vector.bits, ""), "\r\n") {
+		return fmt.Errorf("invalid vector \"%s\"", vector.name)
+	}
+	return vector.flags.Validate()
+}
+
+func (vector GtkwVector) toString() string {
+	return fmt.Sprintf("#{%s} %s\n", vector.name, strings.Join(vector.bits, " "))
+}
@@ -0,0 +1,169 @@
+package vcd
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Problem found by Validate
+type GtkwIssue struct {
+	Line       int // Line number in the save file, starting at 1
+	Trace      string
+	Message    string
+	Suggestion string // Closest existing trace name, for unknown signals
+}
+
+func (issue GtkwIssue) Error() string {
+	message := fmt.Sprintf("line %d: %s", issue.Line, issue.Message)
+	if issue.Trace != "" {
+		message = fmt.Sprintf("line %d: %s: %s", issue.Line, issue.Trace, issue.Message)
+	}
+	if issue.Suggestion != "" {
+		message += fmt.Sprintf(", did you mean %s?", issue.Suggestion)
+	}
+	return message
+}
+
+// Splits a trace name such as mosi[7:0] or mosi[3] into its name and bit range
+// Returns -1 for msb and lsb when there is no range, and false for a malformed range such as bus[a:0]
+func splitBitRange(trace string) (string, int, int, bool) {
+	open := strings.LastIndex(trace, "[")
+	if open < 0 || !strings.HasSuffix(trace, "]") {
+		return trace, -1, -1, true
+	}
+	bits := strings.Split(trace[open+1:len(trace)-1], ":")
+	msb, err := strconv.Atoi(bits[0])
+	if err != nil || len(bits) > 2 {
+		return trace, -1, -1, false
+	}
+	lsb := msb
+	if len(bits) == 2 {
+		if lsb, err = strconv.Atoi(bits[1]); err != nil {
+			return trace, -1, -1, false
+		}
+	}
+	return trace[:open], msb, lsb, true
+}
+
+// Returns the number of single character edits to turn a into b
+func editDistance(a string, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
+
+func closestTrace(name string, variables []VcdDataType) string {
+	closest, distance := "", -1
+	for _, variable := range variables {
+		if d := editDistance(name, variable.VariableName); distance < 0 || d < distance {
+			closest, distance = TraceName(variable), d
+		}
+	}
+	return closest
+}
+
+// Checks a single signal reference, either a trace name or a bit of a concatenated vector
+func checkTraceName(trace string, bitSelect bool, variables []VcdDataType, byName map[string]VcdDataType) string {
+	name, msb, lsb, valid := splitBitRange(trace)
+	if !valid {
+		return "invalid bit range"
+	}
+	variable, ok := byName[name]
+	if !ok {
+		return "unknown signal"
+	}
+	bus := variable.VariableType != "real" && variable.VariableType != "string" && variable.BitDepth > 1
+	switch {
+	case msb < 0 && bus:
+		return fmt.Sprintf("missing bit range, expected %s", TraceName(variable))
+	case msb >= 0 && !bus:
+		return fmt.Sprintf("signal has no bit range, expected %s", TraceName(variable))
+	case msb >= variable.BitDepth || lsb >= variable.BitDepth:
+		return fmt.Sprintf("bit range [%d:%d] exceeds %s", msb, lsb, TraceName(variable))
+	case !bitSelect && msb >= 0 && (msb != variable.BitDepth-1 || lsb != 0):
+		return fmt.Sprintf("wrong bit range, expected %s", TraceName(variable))
+	}
+	return ""
+}
+
+// Checks the traces of a save file against the variables of a dump, such as returned by VcdReader.Variables
+// Reports unknown signals with the closest existing name, wrong bit ranges,
+// analog flags on signals which are not real, and unbalanced groups
+func (save *GtkwSave) Validate(variables []VcdDataType) []GtkwIssue {
+	byName := make(map[string]VcdDataType)
+	for _, variable := range variables {
+		byName[variable.VariableName] = variable
+	}
+	var issues []GtkwIssue
+	report := func(line int, trace string, message string) {
+		issue := GtkwIssue{Line: line + 1, Trace: trace, Message: message}
+		if message == "unknown signal" {
+			name, _, _, _ := splitBitRange(trace)
+			issue.Suggestion = closestTrace(name, variables)
+		}
+		issues = append(issues, issue)
+	}
+
+	type openGroup struct {
+		name string
+		line int
+	}
+	var groups []openGroup
+	flags := GtkwFlags{}
+	for i, line := range save.Lines {
+		switch l := line.(type) {
+		case *GtkwFlags:
+			flags = GtkwFlags{Flags: l.Flags}
+		case *GtkwLabel:
+			if flags.Has("grp_begin") {
+				groups = append(groups, openGroup{l.Text, i})
+			} else if flags.Has("grp_end") {
+				if len(groups) == 0 {
+					report(i, "", fmt.Sprintf("end of group %s without begin", l.Text))
+				} else {
+					if groups[len(groups)-1].name != l.Text {
+						report(i, "", fmt.Sprintf("end of group %s closes group %s", l.Text, groups[len(groups)-1].name))
+					}
+					groups = groups[:len(groups)-1]
+				}
+			}
+		case *GtkwTraceLine:
+			if message := checkTraceName(l.Name, false, variables, byName); message != "" {
+				report(i, l.Name, message)
+			} else if name, _, _, _ := splitBitRange(l.Name); (flags.Has("analog_step") || flags.Has("analog_interpolated")) &&
+				byName[name].VariableType != "real" {
+				report(i, l.Name, "analog display of a signal which is not real")
+			}
+		case *GtkwVectorLine:
+			for _, bit := range l.Bits {
+				if message := checkTraceName(bit, true, variables, byName); message != "" {
+					report(i, bit, message)
+				}
+			}
+		}
+	}
+	for _, group := range groups {
+		report(group.line, "", fmt.Sprintf("group %s is never ended", group.name))
+	}
+	return issues
+}
+
+// Checks the traces written so far against the variables of a dump, see GtkwSave.Validate
+func (gtkw *Gtkw) Validate(variables []VcdDataType) []GtkwIssue {
+	return gtkw.layout.Validate(variables)
+}
@@ -0,0 +1,47 @@
+// Package vcdtest writes small dumps for the tests of the packages reading waveforms
+package vcdtest
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/elamre/vcd"
+)
+
+// Fails the test on an error
+func Check(t testing.TB, e error) {
+	t.Helper()
+	if e != nil {
+		t.Fatal(e)
+	}
+}
+
+// Change of a signal for Write
+type Change struct {
+	Time   uint64
+	Signal string
+	Value  string
+}
+
+// Writes single bit signals of module top and returns the reader for them, which is closed when the test ends
+// Changes must be in time order
+func Write(t testing.TB, timeScale string, signals []string, changes []Change) *vcd.VcdReader {
+	t.Helper()
+	filename := filepath.Join(t.TempDir(), "dump.vcd")
+	writer, e := vcd.New(filename, timeScale)
+	Check(t, e)
+	var variables []vcd.VcdDataType
+	for _, signal := range signals {
+		variables = append(variables, vcd.NewVariable(signal, "wire", 1))
+	}
+	_, e = writer.RegisterVariables("top", variables...)
+	Check(t, e)
+	for _, c := range changes {
+		Check(t, writer.SetValue(c.Time, c.Value, c.Signal))
+	}
+	writer.Close()
+	reader, e := vcd.NewReader(filename)
+	Check(t, e)
+	t.Cleanup(reader.Close)
+	return &reader
+}
@@ -0,0 +1,84 @@
+// Package procfilter implements the GTKWave process filter protocol, so a small Go program
+// can translate the values of ptranslated traces
+//
+// GTKWave starts the filter once and writes every value it displays to its stdin, one per line,
+// formatted as the trace is displayed such as 1A for a hex trace
+// The filter answers every line with one line of text to show, optionally starting with ?color?
+package procfilter
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/elamre/vcd"
+)
+
+// Translates a value as GTKWave displays it into the text to show
+type TranslateFunc func(value string) string
+
+// Reads values from r and writes their translations to w, one line each
+// Every line is flushed right away, GTKWave waits for it before sending the next value
+// Returns nil when r is closed
+func Serve(r io.Reader, w io.Writer, translate TranslateFunc) error {
+	scanner := bufio.NewScanner(r)
+	writer := bufio.NewWriter(w)
+	for scanner.Scan() {
+		text := strings.ReplaceAll(translate(strings.TrimSpace(scanner.Text())), "\n", " ")
+		if _, err := writer.WriteString(text + "\n"); err != nil {
+			return err
+		}
+		if err := writer.Flush(); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// Serves on stdin and stdout, to be called from main
+// Exits with status 1 when the pipes fail
+func Run(translate TranslateFunc) {
+	if err := Serve(os.Stdin, os.Stdout, translate); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// Returns text shown in the given X11 color such as red or DarkBlue
+func Colored(color string, text string) string {
+	return fmt.Sprintf("?%s?%s", color, text)
+}
+
+// Translates values of hex traces with translate
+// Values which are not a number, such as those containing x or z, are shown unchanged
+func Hex(translate func(value uint64) string) TranslateFunc {
+	return func(value string) string {
+		number, err := strconv.ParseUint(value, 16, 64)
+		if err != nil {
+			return value
+		}
+		return translate(number)
+	}
+}
+
+// Translates values of hex traces with the labels and colors of an enum
+// Values which are not mapped are shown unchanged
+func Enum(enum vcd.GtkwEnum) TranslateFunc {
+	labels := make(map[uint64]string)
+	for _, value := range enum.Values() {
+		labels[value.Value] = value.Label
+		if value.Color != "" {
+			labels[value.Value] = Colored(value.Color, value.Label)
+		}
+	}
+	return func(value string) string {
+		number, err := strconv.ParseUint(value, 16, 64)
+		if label, ok := labels[number]; ok && err == nil {
+			return label
+		}
+		return value
+	}
+}
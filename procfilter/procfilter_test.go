@@ -0,0 +1,32 @@
+package procfilter
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/elamre/vcd"
+)
+
+func TestServe(t *testing.T) {
+	var out bytes.Buffer
+	double := Hex(func(value uint64) string { return fmt.Sprint(value * 2) })
+	if err := Serve(strings.NewReader("0A\n1x\nFF\n"), &out, double); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "20\n1x\n510\n" {
+		t.Fatalf("unexpected output %q", out.String())
+	}
+}
+
+func TestEnum(t *testing.T) {
+	enum, err := vcd.NewEnum(2, vcd.EnumValue{Value: 0, Label: "IDLE"}, vcd.EnumValue{Value: 1, Label: "BUSY", Color: "red"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	translate := Enum(enum)
+	if translate("0") != "IDLE" || translate("1") != "?red?BUSY" || translate("3") != "3" {
+		t.Fatalf("unexpected translations %s %s %s", translate("0"), translate("1"), translate("3"))
+	}
+}
@@ -2,8 +2,10 @@ package vcd
 
 import (
 	"bufio"
+	"fmt"
 	"log"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -18,6 +20,10 @@ type VcdReader struct {
 	buffered   *bufio.Reader
 	time       int64
 
+	tolerant     bool
+	truncated    bool
+	completeTime int64
+
 	Date              string
 	Timescale         string
 	Version           string
@@ -42,13 +48,56 @@ func (reader VcdReader) GetIdentifiers() map[string]VcdDataType {
 	return reader.identifierNameMap
 }
 
+// Returns the variables of the header sorted by their full path
+func (reader VcdReader) Variables() []VcdDataType {
+	return sortedVariables(reader.identifierNameMap)
+}
+
+func sortedVariables(identifierNameMap map[string]VcdDataType) []VcdDataType {
+	variables := make([]VcdDataType, 0, len(identifierNameMap))
+	for _, variable := range identifierNameMap {
+		variables = append(variables, variable)
+	}
+	sort.Slice(variables, func(i, j int) bool {
+		return variables[i].VariableName < variables[j].VariableName
+	})
+	return variables
+}
+
+func (reader VcdReader) Metadata() WaveMetadata {
+	return WaveMetadata{Date: reader.Date, Timescale: reader.Timescale, Version: reader.Version, Comment: reader.Comment}
+}
+
+// Enables reading files that were cut off while being written, for example by a crash
+// A truncated final record is dropped instead of causing a panic, see Truncated and LastCompleteTime
+func (reader *VcdReader) SetTolerant(tolerant bool) *VcdReader {
+	reader.tolerant = tolerant
+	return reader
+}
+
+// Returns true when the tolerant reader dropped a truncated final record
+func (reader VcdReader) Truncated() bool {
+	return reader.truncated
+}
+
+// Returns the last timestamp of which all value changes have been read
+// Only valid after Next returned false
+func (reader VcdReader) LastCompleteTime() int64 {
+	return reader.completeTime
+}
+
 func (reader *VcdReader) ParseHeader() {
 	reader.identifierNameMap = make(map[string]VcdDataType)
+	var scopes []string
 	module := ""
 	for ; ; {
 		readStream := ""
 		for ; !strings.HasSuffix(readStream, "$end\n"); {
 			b, err := reader.buffered.ReadByte()
+			if err != nil && reader.tolerant {
+				reader.truncated = true
+				return
+			}
 			check(err)
 			readStream += string(b)
 			if !strings.HasPrefix(readStream, "$") {
@@ -66,12 +115,15 @@ func (reader *VcdReader) ParseHeader() {
 		l := len(delim)
 		switch delim[0] {
 		case "$scope":
-			module += delim[2] + "."
+			scopes = append(scopes, delim[2])
+			module = strings.Join(scopes, ".") + "."
 		case "$upscope":
-			ss := strings.Split(module, ".")
-			module = ""
-			for _, up := range ss[:len(ss)-1] {
-				module += up + "."
+			if len(scopes) > 0 {
+				scopes = scopes[:len(scopes)-1]
+			}
+			module = strings.Join(scopes, ".") + "."
+			if len(scopes) == 0 {
+				module = ""
 			}
 		case "$comment":
 			for _, ss := range delim[1 : l-2] {
@@ -80,7 +132,7 @@ func (reader *VcdReader) ParseHeader() {
 		case "$var":
 			var datType VcdDataType
 			datType.VariableType = delim[1]
-			datType.BitDepth = int(check2(strconv.ParseInt(delim[2], 10, 32)).(int64))
+			datType.BitDepth = parseVarSize(delim[2])
 			datType.identifier = delim[3]
 			datType.VariableName = module + delim[4]
 			switch delim[1] {
@@ -90,6 +142,8 @@ func (reader *VcdReader) ParseHeader() {
 				datType.marshal = &VcdStringType{}
 			case "real":
 				datType.marshal = VcdRealType{}
+			case "port":
+				datType.marshal = VcdPortType{}
 			default:
 				panic("Unknown variable")
 			}
@@ -101,9 +155,10 @@ func (reader *VcdReader) ParseHeader() {
 				reader.Version += ss
 			}
 		case "$timescale":
-			reader.Timescale = delim[1]
+			// Number and unit may be separated, as in 1 ns, or on lines of their own
+			reader.Timescale = strings.Join(strings.Fields(strings.Join(delim[1:l-2], " ")), "")
 		case "$enddefinitions":
-			break
+			return
 		default:
 			log.Printf("Unknown header: %s", delim[0])
 		}
@@ -114,44 +169,110 @@ func (reader *VcdReader) ReadAll() map[string][]ReadValue {
 	if reader.identifierNameMap == nil {
 		reader.ParseHeader()
 	}
+	return readAllValues(reader.identifierNameMap, reader.Next)
+}
+
+// Collects all values returned by next, keyed by variable name
+func readAllValues(identifierNameMap map[string]VcdDataType, next func() (bool, int64, string, interface{})) map[string][]ReadValue {
 	retVal := make(map[string][]ReadValue)
-	for k, _ := range reader.identifierNameMap {
+	for k, _ := range identifierNameMap {
 		retVal[k] = make([]ReadValue, 0)
 	}
 	for ; ; {
-		valid, time, identifier, value := reader.Next()
+		valid, time, identifier, value := next()
 		if !valid {
 			break
 		}
 		// TODO use some linkedlist
 		retVal[identifier] = append(retVal[identifier], ReadValue{time, value})
 	}
-	for k, v := range reader.identifierNameMap {
+	for k, v := range identifierNameMap {
 		retVal[v.VariableName] = retVal[k]
 		delete(retVal, k)
 	}
 	return retVal
 }
 
+// Parses the size of a variable, either a bit count or an extended VCD bit range such as [7:0]
+func parseVarSize(size string) int {
+	var msb, lsb int
+	if _, err := fmt.Sscanf(size, "[%d:%d]", &msb, &lsb); err == nil {
+		if msb < lsb {
+			msb, lsb = lsb, msb
+		}
+		return msb - lsb + 1
+	}
+	return int(check2(strconv.ParseInt(size, 10, 32)).(int64))
+}
+
 // return: Completed, Time, Identifier, Value
+// A tolerant reader drops a final line without line ending, as it might have been cut off
 func (reader *VcdReader) Next() (bool, int64, string, interface{}) {
-	var line []byte
-	var err error
-
-	for ; ; {
-		line, _, err = reader.buffered.ReadLine()
+	for {
+		line, err := reader.buffered.ReadString('\n')
 		if err != nil {
-			return false, 0, "", ""
+			if line == "" {
+				reader.completeTime = reader.time
+				return false, 0, "", ""
+			}
+			if reader.tolerant {
+				reader.truncated = true
+				if strings.HasPrefix(line, "#") {
+					reader.completeTime = reader.time
+				}
+				return false, 0, "", ""
+			}
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			continue
 		}
-		dec := strings.Split(string(line), " ")
-		if len(dec) == 1 {
-			time, err := strconv.ParseInt(string(line[1:]), 10, 64)
+		dec := strings.Split(line, " ")
+		if strings.HasPrefix(line, "$comment") {
+			// Comments may span lines up to $end
+			for !strings.Contains(line, "$end") {
+				if line, err = reader.buffered.ReadString('\n'); err != nil {
+					break
+				}
+			}
+			continue
+		} else if strings.HasPrefix(line, "$") {
+			// Dump sections such as $dumpvars and $dumpports only wrap value changes
+			continue
+		} else if strings.HasPrefix(line, "#") {
+			time, err := strconv.ParseInt(line[1:], 10, 64)
 			if err != nil {
 				panic(err)
 			}
+			reader.completeTime = reader.time
 			reader.time = time
+		} else if len(dec) == 1 {
+			// Scalar value change such as 1!
+			if _, ok := reader.identifierNameMap[line[1:]]; !ok {
+				log.Printf("Unknown identifier in %s", line)
+				continue
+			}
+			return true, reader.time, line[1:], line[:1]
+		} else if strings.HasPrefix(line, "p") && len(dec) == 4 {
+			// Extended VCD port value change such as pD 6 0 <0
+			variable, ok := reader.identifierNameMap[dec[3]]
+			if !ok {
+				log.Printf("Unknown identifier in %s", line)
+				continue
+			}
+			val, err := variable.marshal.parse(strings.Join(dec[:3], " "))
+			if err != nil {
+				log.Printf("Error unmarshalling port value %s: %v", line, err)
+			} else {
+				return true, reader.time, dec[3], val
+			}
 		} else {
-			val, err := reader.identifierNameMap[dec[1]].marshal.parse(dec[0])
+			variable, ok := reader.identifierNameMap[dec[1]]
+			if !ok {
+				log.Printf("Unknown identifier in %s", line)
+				continue
+			}
+			val, err := variable.marshal.parse(dec[0])
 			if err != nil {
 				// More info
 				log.Printf("Error unmarshalling")
@@ -159,8 +280,5 @@ func (reader *VcdReader) Next() (bool, int64, string, interface{}) {
 				return true, reader.time, dec[1], val
 			}
 		}
-
 	}
-
-	return false, 0, "", ""
 }
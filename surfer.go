@@ -0,0 +1,166 @@
+package vcd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Surfer formats for the GTKWave display format flags
+var surferFormats = []struct {
+	flag   GtkwFlag
+	format string
+}{
+	{FlagHex, "Hexadecimal"},
+	{FlagDec, "Unsigned"},
+	{FlagSigned, "Signed"},
+	{FlagBin, "Binary"},
+	{FlagOct, "Octal"},
+	{FlagAscii, "ASCII"},
+}
+
+// Surfer colors for the GTKWave trace colors
+var surferColors = map[GtkwColor]string{
+	ColorRed:    "Red",
+	ColorOrange: "Orange",
+	ColorYellow: "Yellow",
+	ColorGreen:  "Green",
+	ColorBlue:   "Blue",
+	ColorIndigo: "Blue",
+	ColorViolet: "Violet",
+}
+
+// Returns the id Surfer shows next to the item at index, a to z and then aa, ab... when there are more items
+func surferItemId(index int, items int) string {
+	width := 1
+	for capacity := 26; capacity < items; capacity *= 26 {
+		width++
+	}
+	id := make([]byte, width)
+	for i := width - 1; i >= 0; i-- {
+		id[i] = byte('a' + index%26)
+		index /= 26
+	}
+	return string(id)
+}
+
+// Writes the save file as a Surfer command file, so the same layout can be loaded in Surfer
+// Groups and comments become dividers, as Surfer has no command to create groups
+// Nested groups are flattened into dividers named by their path such as outer/inner, losing their nesting
+// Vectors concatenated from bits have no Surfer equivalent and are left out
+func (save *GtkwSave) WriteSurfer(w io.Writer) error {
+	var commands []string
+	type itemStyle struct {
+		index    int
+		commands []string
+	}
+	var styles []itemStyle
+	items := 0
+	var groups []string
+	flags := GtkwFlags{}
+	color := ColorNormal
+	markerNames := make(map[int]string)
+	for _, line := range save.Lines {
+		if setting, ok := line.(*GtkwSetting); ok && setting.Key == "markername" && len(setting.Value) > 1 {
+			markerNames[int(setting.Value[0]-'A')] = setting.Value[1:]
+		}
+	}
+	for _, line := range save.Lines {
+		switch l := line.(type) {
+		case *GtkwSetting:
+			switch l.Key {
+			case "dumpfile":
+				commands = append(commands, "load_file "+strings.Trim(l.Value, "\""))
+			case "color":
+				index, _ := strconv.Atoi(l.Value)
+				color = GtkwColor(index)
+			}
+		case *GtkwFlags:
+			flags = GtkwFlags{Flags: l.Flags}
+		case *GtkwLabel:
+			switch {
+			case flags.Has("grp_begin"):
+				groups = append(groups, l.Text)
+				commands = append(commands, "divider_add "+strings.Join(groups, "/"))
+				items++
+			case flags.Has("grp_end"):
+				if len(groups) > 0 {
+					groups = groups[:len(groups)-1]
+				}
+			case flags.Has("analog_blank_stretch"):
+			default:
+				commands = append(commands, strings.TrimSpace("divider_add "+l.Text))
+				items++
+			}
+		case *GtkwTraceLine:
+			name, _, _, _ := splitBitRange(l.Name)
+			commands = append(commands, "variable_add "+name)
+			var style []string
+			for _, format := range surferFormats {
+				if GtkwFlag(flags.Flags)&format.flag != 0 {
+					style = append(style, "item_set_format "+format.format)
+					break
+				}
+			}
+			if surferColor, ok := surferColors[color]; ok {
+				style = append(style, "item_set_color "+surferColor)
+			}
+			if len(style) > 0 {
+				styles = append(styles, itemStyle{items, style})
+			}
+			items++
+			color = ColorNormal
+		case *GtkwVectorLine:
+			color = ColorNormal
+		}
+	}
+	// Items are addressed by their position, the ids depend on the number of items so they are styled after adding all
+	for _, style := range styles {
+		commands = append(commands, "item_focus "+surferItemId(style.index, items))
+		commands = append(commands, style.commands...)
+	}
+	if len(styles) > 0 {
+		commands = append(commands, "item_unfocus")
+	}
+	if zoom := save.Zoom(); zoom != nil {
+		if zoom.Primary >= 0 {
+			commands = append(commands, fmt.Sprintf("cursor_set %d", zoom.Primary))
+		}
+		for i, time := range zoom.Markers {
+			if time < 0 {
+				continue
+			}
+			name, ok := markerNames[i]
+			if !ok {
+				name = string(rune('A' + i))
+			}
+			commands = append(commands, fmt.Sprintf("marker_set %s %d", name, time))
+		}
+	}
+	for _, command := range commands {
+		if _, err := io.WriteString(w, command+"\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Writes the save file as a Surfer command file with the given name, see WriteSurfer
+func (save *GtkwSave) WriteSurferFile(filename string) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if err = save.WriteSurfer(f); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Writes the layout written so far as a Surfer command file, see GtkwSave.WriteSurfer
+func (gtkw *Gtkw) WriteSurfer(w io.Writer) error {
+	return gtkw.layout.WriteSurfer(w)
+}
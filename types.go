@@ -9,7 +9,7 @@ import (
 
 // Both vector and wire result to vectortype
 // TODO implement missing types
-var supportedTypes = []string{"vector", "wire", "real", "string"}
+var supportedTypes = []string{"vector", "wire", "real", "string", "port"}
 
 // Not really an error, but prevents writing of empty strings when there was no change. This causes glitches
 // TODO maybe eventually add a boolean return for every marshal instead of throwing and comparing errors
@@ -69,6 +69,12 @@ type VcdVectorType struct {
 func (t VcdVectorType) format(value string) (string, error) {
 	if value == "x" || value == "z" {
 		return "b" + value, nil
+	} else if strings.HasPrefix(value, "b") && len(value) > 1 && strings.Trim(value[1:], "01xz") == "" {
+		// Binary value with unknown bits such as b10x1
+		if len(value)-1 > t.bitDepth {
+			return "bz", fmt.Errorf("vector %s is wider than bitdepth %d", value, t.bitDepth)
+		}
+		return value, nil
 	} else if num, err := strconv.ParseInt(value, 10, 64); err == nil {
 		if uint64(num) > t.maxVal {
 			return "bz", fmt.Errorf("vector is larger %d than bitdepth allows 2^%d=%d", num, t.bitDepth, t.maxVal)
@@ -76,7 +82,7 @@ func (t VcdVectorType) format(value string) (string, error) {
 			return fmt.Sprintf("b%b", num), nil
 		}
 	} else {
-		return "bz", fmt.Errorf("value %s is not a number, z, or x", value)
+		return "bz", fmt.Errorf("value %s is not a number, binary value, z, or x", value)
 	}
 }
 
@@ -105,3 +111,129 @@ func (t VcdStringType) parse(value string) (interface{}, error) {
 	value = value[1:]
 	return value, nil
 }
+
+// Defines extended VCD port types such as pDU 60 06
+type VcdPortType struct {
+	bitDepth int
+}
+
+func (t VcdPortType) format(value string) (string, error) {
+	port, err := ParsePortValue(value)
+	if err != nil {
+		return "", err
+	}
+	if t.bitDepth > 0 && len(port.State) != t.bitDepth {
+		return "", fmt.Errorf("port value %s has %d states, expected %d", value, len(port.State), t.bitDepth)
+	}
+	return "p" + port.String(), nil
+}
+
+func (t VcdPortType) parse(value string) (interface{}, error) {
+	return ParsePortValue(value[1:])
+}
+
+// Direction of an extended VCD port, derived from its state character
+type PortDirection int
+
+const (
+	PortUnknown PortDirection = iota // Both or neither side drives the port
+	PortInput                        // The test fixture drives the port
+	PortOutput                       // The device under test drives the port
+)
+
+// State characters per direction and the logic level they represent
+const (
+	portInputStates   = "DUNZdu"
+	portInputLevels   = "01xz01"
+	portOutputStates  = "LHXTlh"
+	portOutputLevels  = "01xz01"
+	portUnknownStates = "01?FAaBbCcf"
+	portUnknownLevels = "01xzxxxxxxz"
+)
+
+// Value of an extended VCD port: one state character per bit, followed by the strength of the 0 and 1 drivers
+// Strengths are digits from 0 (highz) to 7 (supply), either one for the whole port or one per bit
+type EvcdPortValue struct {
+	State     string
+	Strength0 string
+	Strength1 string
+}
+
+// Parses a port value as written after the p of a value change, e.g. "DU 6 0"
+func ParsePortValue(value string) (EvcdPortValue, error) {
+	fields := strings.Fields(value)
+	if len(fields) != 3 {
+		return EvcdPortValue{}, fmt.Errorf("port value %s should contain a state and two strengths", value)
+	}
+	port := EvcdPortValue{State: fields[0], Strength0: fields[1], Strength1: fields[2]}
+	for _, state := range port.State {
+		if !strings.ContainsRune(portInputStates+portOutputStates+portUnknownStates, state) {
+			return EvcdPortValue{}, fmt.Errorf("invalid port state %c in %s", state, value)
+		}
+	}
+	for _, strength := range port.Strength0 + port.Strength1 {
+		if strength < '0' || strength > '7' {
+			return EvcdPortValue{}, fmt.Errorf("invalid port strength %c in %s", strength, value)
+		}
+	}
+	return port, nil
+}
+
+func (v EvcdPortValue) String() string {
+	return v.State + " " + v.Strength0 + " " + v.Strength1
+}
+
+// Returns the direction of a bit, bit 0 is the most significant bit as written in the dump
+// Bits outside the port are PortUnknown
+func (v EvcdPortValue) Direction(bit int) PortDirection {
+	if bit < 0 || bit >= len(v.State) {
+		return PortUnknown
+	}
+	state := v.State[bit]
+	if strings.IndexByte(portInputStates, state) >= 0 {
+		return PortInput
+	} else if strings.IndexByte(portOutputStates, state) >= 0 {
+		return PortOutput
+	}
+	return PortUnknown
+}
+
+// Returns the logic level 0, 1, x or z of a bit, x for bits outside the port
+func (v EvcdPortValue) Level(bit int) byte {
+	if bit < 0 || bit >= len(v.State) {
+		return 'x'
+	}
+	state := v.State[bit]
+	if i := strings.IndexByte(portInputStates, state); i >= 0 {
+		return portInputLevels[i]
+	} else if i := strings.IndexByte(portOutputStates, state); i >= 0 {
+		return portOutputLevels[i]
+	} else if i := strings.IndexByte(portUnknownStates, state); i >= 0 {
+		return portUnknownLevels[i]
+	}
+	return 'x'
+}
+
+// Returns the logic levels of all bits, comparable to a vector value
+func (v EvcdPortValue) Value() string {
+	levels := make([]byte, len(v.State))
+	for i := range levels {
+		levels[i] = v.Level(i)
+	}
+	return string(levels)
+}
+
+// Returns the strength of the 0 and 1 drivers of a bit
+func (v EvcdPortValue) Strength(bit int) (int, int) {
+	return strengthDigit(v.Strength0, bit), strengthDigit(v.Strength1, bit)
+}
+
+func strengthDigit(strengths string, bit int) int {
+	if len(strengths) == 0 || bit < 0 {
+		return 0
+	}
+	if bit >= len(strengths) {
+		bit = len(strengths) - 1
+	}
+	return int(strengths[bit] - '0')
+}
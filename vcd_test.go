@@ -1,8 +1,13 @@
 package vcd
 
 import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
 	"fmt"
+	"io"
 	"os"
+	"strings"
 	"testing"
 )
 
@@ -44,7 +49,7 @@ func TestFormatTypes(t *testing.T) {
 
 func TestCreate(t *testing.T) {
 	t.Run("Creating file", func(t *testing.T) {
-		writer, e := New(testDirectory+testFile, "10")
+		writer, e := New(testDirectory+testFile+".vcd", "10")
 		checkT(t, e)
 		defer writer.Close()
 		_, e = os.Stat(testDirectory + testFile + ".vcd")
@@ -63,3 +68,751 @@ func TestMain(m *testing.M) {
 	}
 	os.Exit(code)
 }
+
+func TestTruncated(t *testing.T) {
+	filename := testDirectory + "truncated.vcd"
+	writer, e := New(filename, "1ns")
+	checkT(t, e)
+	writer.SetFlushThreshold(1)
+	_, e = writer.RegisterVariables("top", NewVariable("data", "wire", 8))
+	checkT(t, e)
+	checkT(t, writer.SetValue(0, "1", "data"))
+	checkT(t, writer.SetValue(10, "2", "data"))
+	checkT(t, writer.SetValue(20, "3", "data"))
+	// Everything up to the last time advance must be on disk without closing
+	contents, e := os.ReadFile(filename)
+	checkT(t, e)
+	writer.Close()
+	// Simulate a crash halfway through the last record
+	checkT(t, os.WriteFile(filename, append(contents, []byte("#2")...), 0644))
+
+	reader, e := NewReader(filename)
+	checkT(t, e)
+	defer reader.Close()
+	reader.SetTolerant(true)
+	values := reader.ReadAll()
+	if !reader.Truncated() {
+		t.Fatal("expected truncated file")
+	}
+	if reader.LastCompleteTime() != 10 {
+		t.Fatalf("expected last complete time 10 got %d", reader.LastCompleteTime())
+	}
+	if len(values["top.data"]) != 2 {
+		t.Fatalf("expected 2 values got %+v", values["top.data"])
+	}
+}
+
+func TestLargeTimestep(t *testing.T) {
+	filename := testDirectory + "large.vcd"
+	writer, e := New(filename, "1ns")
+	checkT(t, e)
+	writer.SetFlushThreshold(64)
+	_, e = writer.RegisterVariables("top", NewVariable("data", "wire", 8))
+	checkT(t, e)
+	// Every time writes more than the threshold and more than the buffer holds
+	for time := uint64(0); time <= 20; time += 10 {
+		for i := 0; i < 500; i++ {
+			checkT(t, writer.SetValue(time, fmt.Sprint(i%256), "data"))
+		}
+	}
+	contents, e := os.ReadFile(filename)
+	checkT(t, e)
+	writer.Close()
+	if !strings.HasSuffix(string(contents), "b11110011 !\n") || !strings.Contains(string(contents), "#10\n") ||
+		strings.Contains(string(contents), "#20") {
+		t.Fatalf("expected the file to end with the complete values of time 10:\n%s", contents[max(len(contents)-100, 0):])
+	}
+}
+
+func TestFstWriter(t *testing.T) {
+	filename := testDirectory + "writer.fst"
+	writer, e := NewFst(filename, "10ps")
+	checkT(t, e)
+	writer.SetVersion("Current").SetComment("Test")
+	_, e = writer.RegisterVariables("top",
+		NewVariable("data", "wire", 8),
+		NewVariable("cs", "wire", 1),
+		NewVariable("analogue", "real", 1),
+		NewVariable("command", "string", 1),
+	)
+	checkT(t, e)
+	checkT(t, writer.SetValue(0, "z", "data"))
+	checkT(t, writer.SetValue(0, "1", "cs"))
+	checkT(t, writer.SetValue(10, "80", "data"))
+	checkT(t, writer.SetValue(10, "1.5", "analogue"))
+	checkT(t, writer.SetValue(20, "go", "command"))
+	if writer.SetValue(30, "256", "data") == nil {
+		t.Fatal("expected an error for a value larger than the bitdepth")
+	}
+	writer.Close()
+
+	contents, e := os.ReadFile(filename)
+	checkT(t, e)
+	// Walk the blocks: header, value changes, geometry and hierarchy
+	var blocks []byte
+	for pos := 0; pos < len(contents); {
+		blocks = append(blocks, contents[pos])
+		pos += 1 + int(binary.BigEndian.Uint64(contents[pos+1:]))
+	}
+	if string(blocks) != string([]byte{fstBlockHeader, fstBlockValueChangeDynAlias, fstBlockGeometry, fstBlockHierarchy}) {
+		t.Fatalf("unexpected blocks %v", blocks)
+	}
+	if contents[73] != byte(0xF5) {
+		t.Fatalf("expected timescale -11 got %d", int8(contents[73]))
+	}
+	// The value changes read back as written
+	reader, e := NewFstReader(filename)
+	checkT(t, e)
+	defer reader.Close()
+	values := reader.ReadAll()
+	if data := values["top.data"]; len(data) != 2 || data[0].Value != "zzzzzzzz" || data[1].Time != 10 || data[1].Value != "01010000" {
+		t.Fatalf("unexpected data values %+v", data)
+	}
+	if cs := values["top.cs"]; len(cs) != 1 || cs[0].Value != "1" {
+		t.Fatalf("unexpected cs values %+v", cs)
+	}
+	if analogue := values["top.analogue"]; len(analogue) == 0 || analogue[len(analogue)-1] != (ReadValue{Time: 10, Value: 1.5}) {
+		t.Fatalf("unexpected analogue values %+v", analogue)
+	}
+	if command := values["top.command"]; len(command) == 0 || command[len(command)-1] != (ReadValue{Time: 20, Value: "go"}) {
+		t.Fatalf("unexpected command values %+v", command)
+	}
+}
+
+func TestFstReader(t *testing.T) {
+	filename := testDirectory + "reader.fst"
+	writer, e := NewFst(filename, "1ns")
+	checkT(t, e)
+	writer.SetVersion("Current").SetComment("Test")
+	_, e = writer.RegisterVariables("top.logic",
+		NewVariable("data", "wire", 12),
+		NewVariable("cs", "wire", 1),
+	)
+	checkT(t, e)
+	_, e = writer.RegisterVariables("top",
+		NewVariable("analogue", "real", 1),
+		NewVariable("command", "string", 1),
+	)
+	checkT(t, e)
+	checkT(t, writer.SetValue(0, "1", "cs"))
+	checkT(t, writer.SetValue(0, "0.5", "analogue"))
+	for i := uint64(1); i < 100; i++ {
+		checkT(t, writer.SetValue(i*10, fmt.Sprint(i*37%4096), "data"))
+		if i%7 == 0 {
+			checkT(t, writer.SetValue(i*10, "z", "cs"))
+			checkT(t, writer.SetValue(i*10, fmt.Sprintf("command %d", i), "command"))
+		}
+	}
+	writer.SetTimestamp(2000)
+	writer.Close()
+
+	reader, e := NewFstReader(filename)
+	checkT(t, e)
+	defer reader.Close()
+	values := reader.ReadAll()
+	if reader.Timescale != "1ns" || reader.Version != "Current" || reader.Comment != "Test " {
+		t.Fatalf("unexpected header %s %s %s", reader.Timescale, reader.Version, reader.Comment)
+	}
+	data := values["top.logic.data"]
+	if len(data) != 100 || data[0].Value != "xxxxxxxxxxxx" || data[2].Time != 20 || data[2].Value != "000001001010" {
+		t.Fatalf("unexpected data values %+v", data[:3])
+	}
+	cs := values["top.logic.cs"]
+	if len(cs) != 15 || cs[0].Value != "1" || cs[1].Time != 70 || cs[1].Value != "z" {
+		t.Fatalf("unexpected cs values %+v", cs)
+	}
+	if analogue := values["top.analogue"]; len(analogue) != 1 || analogue[0].Value != 0.5 {
+		t.Fatalf("unexpected analogue values %+v", analogue)
+	}
+	if command := values["top.command"]; len(command) != 14 || command[13].Value != "command 98" {
+		t.Fatalf("unexpected command values %+v", command)
+	}
+}
+
+// Adds a variable to the hierarchy of an FST file which aliases the values of handle
+func addFstAlias(t *testing.T, filename string, varType byte, name string, length int, handle int) {
+	contents, e := os.ReadFile(filename)
+	checkT(t, e)
+	for offset := 0; offset < len(contents); {
+		blockEnd := offset + 1 + int(binary.BigEndian.Uint64(contents[offset+1:]))
+		if contents[offset] != fstBlockHierarchy {
+			offset = blockEnd
+			continue
+		}
+		z, e := gzip.NewReader(bytes.NewReader(contents[offset+17 : blockEnd]))
+		checkT(t, e)
+		hierarchy, e := io.ReadAll(z)
+		checkT(t, e)
+		var entry bytes.Buffer
+		entry.Write([]byte{varType, 0})
+		entry.WriteString(name + "\x00")
+		writeVarint(&entry, uint64(length))
+		writeVarint(&entry, uint64(handle))
+		// Insert before the last upscope
+		hierarchy = append(hierarchy[:len(hierarchy)-1], append(entry.Bytes(), fstUpscope)...)
+
+		var compressed bytes.Buffer
+		w := gzip.NewWriter(&compressed)
+		_, e = w.Write(hierarchy)
+		checkT(t, e)
+		checkT(t, w.Close())
+		var block bytes.Buffer
+		block.WriteByte(fstBlockHierarchy)
+		writeUint64(&block, uint64(compressed.Len()+16))
+		writeUint64(&block, uint64(len(hierarchy)))
+		block.Write(compressed.Bytes())
+		contents = append(append(contents[:offset:offset], block.Bytes()...), contents[blockEnd:]...)
+		checkT(t, os.WriteFile(filename, contents, 0644))
+		return
+	}
+	t.Fatal("no hierarchy block")
+}
+
+func TestFstAlias(t *testing.T) {
+	filename := testDirectory + "alias.fst"
+	writer, e := NewFst(filename, "1ns")
+	checkT(t, e)
+	_, e = writer.RegisterVariables("top", NewVariable("data", "wire", 4), NewVariable("cs", "wire", 1))
+	checkT(t, e)
+	checkT(t, writer.SetValue(0, "1", "cs"))
+	checkT(t, writer.SetValue(10, "5", "data"))
+	checkT(t, writer.SetValue(20, "6", "data"))
+	writer.Close()
+	addFstAlias(t, filename, fstVarWire, "data_alias", 4, 1)
+	// Aliases may be declared with another type, such as a reg port of a module
+	addFstAlias(t, filename, 5, "data_copy", 4, 1)
+
+	reader, e := NewFstReader(filename)
+	checkT(t, e)
+	defer reader.Close()
+	values := reader.ReadAll()
+	for _, name := range []string{"top.data", "top.data_alias", "top.data_copy"} {
+		data := values[name]
+		if len(data) != 3 || data[1].Time != 10 || data[1].Value != "0101" || data[2].Value != "0110" {
+			t.Fatalf("unexpected %s values %+v", name, data)
+		}
+	}
+	if cs := values["top.cs"]; len(cs) != 1 || cs[0].Value != "1" {
+		t.Fatalf("unexpected cs values %+v", cs)
+	}
+	types := make(map[string]string)
+	for _, variable := range reader.Variables() {
+		types[variable.VariableName] = variable.VariableType
+	}
+	if types["top.data"] != "wire" || types["top.data_copy"] != "reg" {
+		t.Fatalf("unexpected variable types %v", types)
+	}
+}
+
+func TestEvcd(t *testing.T) {
+	filename := testDirectory + "ports.vcd"
+	writer, e := New(filename, "1ns")
+	checkT(t, e)
+	_, e = writer.RegisterVariables("top",
+		NewVariable("clk", "port", 1),
+		NewVariable("data", "port", 4),
+		NewVariable("count", "wire", 4),
+	)
+	checkT(t, e)
+	checkT(t, writer.SetValue(0, "D 6 0", "clk"))
+	checkT(t, writer.SetValue(0, "DUHL 6666 0000", "data"))
+	checkT(t, writer.SetValue(0, "3", "count"))
+	checkT(t, writer.SetValue(5, "U 0 6", "clk"))
+	writer.Close()
+
+	contents, e := os.ReadFile(filename)
+	checkT(t, e)
+	// Add a section as written by simulators
+	contents = append(contents, []byte("#10\n$dumpports\npZ 0 0 <!\n1#\n$end\n")...)
+	checkT(t, os.WriteFile(filename, contents, 0644))
+
+	reader, e := NewReader(filename)
+	checkT(t, e)
+	defer reader.Close()
+	values := reader.ReadAll()
+	clk := values["top.clk"]
+	if len(clk) != 3 || clk[1].Value.(EvcdPortValue).Value() != "1" || clk[2].Value.(EvcdPortValue).Direction(0) != PortInput {
+		t.Fatalf("unexpected clk values %+v", clk)
+	}
+	data := values["top.data"][0].Value.(EvcdPortValue)
+	if data.Value() != "0110" || data.Direction(2) != PortOutput {
+		t.Fatalf("unexpected data value %+v", data)
+	}
+	if strength0, strength1 := data.Strength(1); strength0 != 6 || strength1 != 0 {
+		t.Fatalf("unexpected strengths %d %d", strength0, strength1)
+	}
+	if data.Direction(4) != PortUnknown || data.Direction(-1) != PortUnknown || data.Level(4) != 'x' || data.Level(-1) != 'x' {
+		t.Fatalf("unexpected bits outside the port %+v", data)
+	}
+	if strength0, strength1 := data.Strength(-1); strength0 != 0 || strength1 != 0 {
+		t.Fatalf("unexpected strengths %d %d outside the port", strength0, strength1)
+	}
+	if count := values["top.count"]; len(count) != 2 || count[1].Value != "1" {
+		t.Fatalf("unexpected count values %+v", count)
+	}
+	if _, e := ParsePortValue("Q 6 0"); e == nil {
+		t.Fatal("expected an error for an invalid state")
+	}
+}
+
+func TestWaveRegistry(t *testing.T) {
+	for _, filename := range []string{"wave.vcd", "wave.fst"} {
+		writer, e := CreateWave(testDirectory+filename, "1us")
+		checkT(t, e)
+		writer.SetMetadata("Current", "")
+		_, e = writer.RegisterVariables("top", NewVariable("analogue", "real", 1))
+		checkT(t, e)
+		checkT(t, writer.SetValue(0, "1.5", "analogue"))
+		checkT(t, writer.SetValue(10, "2.5", "analogue"))
+		writer.Close()
+	}
+	// Detection by contents must not rely on the extension
+	checkT(t, os.Rename(testDirectory+"wave.fst", testDirectory+"wave.vcd.bin"))
+	for _, filename := range []string{"wave.vcd", "wave.vcd.bin"} {
+		reader, e := OpenWave(testDirectory + filename)
+		checkT(t, e)
+		values := reader.ReadAll()
+		if analogue := values["top.analogue"]; len(analogue) != 2 || analogue[1].Value != 2.5 || analogue[1].Time != 10 {
+			t.Fatalf("%s: unexpected values %+v", filename, analogue)
+		}
+		if reader.Metadata().Timescale != "1us" {
+			t.Fatalf("%s: unexpected timescale %s", filename, reader.Metadata().Timescale)
+		}
+		reader.Close()
+	}
+	if _, e := CreateWave(testDirectory+"wave.txt", "1us"); e == nil {
+		t.Fatal("expected an error for an unknown extension")
+	}
+}
+
+var testGtkwSave = `[*]
+[*] GTKWave Analyzer v3.3.104 (w)1999-2020 BSI
+[*] Mon Oct 19 12:00:00 2026
+[*]
+[dumpfile] "/tmp/example.vcd"
+[dumpfile_mtime] "Mon Oct 19 11:59:58 2026"
+[dumpfile_size] 1024
+[savefile] "/tmp/example.gtkw"
+[timestart] 0
+[size] 1200 700
+[pos] -1 -1
+*-5.000000 150 -1 -1 -1 -1 -1 -1 -1 -1 -1 -1 -1 -1 -1 -1 -1 -1 -1 -1 -1 -1 -1 -1 -1 -1 -1 -1
+[treeopen] example.
+[sst_width] 233
+[signals_width] 150
+[sst_expanded] 1
+[sst_vpaned_height] 158
+@c00200
+-SPI
+@22
+[color] 3
++{mosi} example.logic.mosi[7:0]
+example.logic.miso[7:0]
+@1401200
+-SPI
+@28
+#{bits} example.logic.mosi[1] example.logic.mosi[0]
+@200
+-
+@2022
+^1 /tmp/states.txt
+example.state[3:0]
+[pattern_trace] 1
+[pattern_trace] 0
+`
+
+func TestParseGtkw(t *testing.T) {
+	save, e := ParseGtkw(strings.NewReader(testGtkwSave))
+	checkT(t, e)
+	if save.String() != testGtkwSave {
+		t.Fatalf("save file not written back losslessly:\n%s", save.String())
+	}
+	if save.Dumpfile() != "/tmp/example.vcd" || save.Zoom().Primary != 150 || len(save.Zoom().Markers) != 26 {
+		t.Fatal("unexpected header")
+	}
+	traces := save.Traces()
+	if len(traces) != 4 {
+		t.Fatalf("expected 4 traces got %+v", traces)
+	}
+	if traces[0].Alias != "mosi" || traces[0].Color != 3 || traces[0].Groups[0] != "SPI" || !traces[0].Flags.Has("hex") {
+		t.Fatalf("unexpected first trace %+v", traces[0])
+	}
+	if traces[1].Color != 0 || len(traces[2].Groups) != 0 || len(traces[2].Bits) != 2 {
+		t.Fatalf("unexpected traces %+v", traces[1:3])
+	}
+	if traces[3].Filter == nil || traces[3].Filter.Path != "/tmp/states.txt" {
+		t.Fatalf("unexpected filter %+v", traces[3])
+	}
+
+	save.SetDumpfile("other.fst")
+	save.SetSetting("sst_width", "300")
+	save.Add(Trace("example.command", "command"))
+	expected := strings.Replace(testGtkwSave, "/tmp/example.vcd", "other.fst", 1)
+	expected = strings.Replace(expected, "[sst_width] 233", "[sst_width] 300", 1)
+	expected += "@0\n+{command} example.command\n"
+	if save.String() != expected {
+		t.Fatalf("unexpected modified save file:\n%s", save.String())
+	}
+}
+
+func TestGtkwView(t *testing.T) {
+	gtkw := NewGtkw(testDirectory + "view")
+	gtkw.SetDumpfile("view.vcd")
+	gtkw.SetTimestart(100)
+	gtkw.SetSize(1200, 700)
+	gtkw.SetPos(-1, -1)
+	checkT(t, gtkw.SetZoom(-4, 150, GtkwMarker{Time: 120, Name: "edge"}, GtkwMarker{Time: -1}, GtkwMarker{Time: 180}))
+	gtkw.TreeOpen("example", "example.logic.")
+	gtkw.SetSstWidth(200)
+	gtkw.SetSignalsWidth(160)
+	if gtkw.SetZoom(0, 0, make([]GtkwMarker, 27)...) == nil {
+		t.Fatal("expected an error for too many markers")
+	}
+	gtkw.Close()
+
+	save, e := ParseGtkwFile(testDirectory + "view.gtkw")
+	checkT(t, e)
+	zoom := save.Zoom()
+	if zoom.Zoom != -4 || zoom.Primary != 150 || len(zoom.Markers) != 26 || zoom.Markers[0] != 120 || zoom.Markers[1] != -1 || zoom.Markers[2] != 180 {
+		t.Fatalf("unexpected zoom %+v", zoom)
+	}
+	if save.Setting("markername").Value != "Aedge" || save.Setting("treeopen").Value != "example." || save.Setting("timestart").Value != "100" {
+		t.Fatalf("unexpected settings:\n%s", save)
+	}
+}
+
+func TestGenerateGtkw(t *testing.T) {
+	writer, e := New(testDirectory+"layout.vcd", "1ns")
+	checkT(t, e)
+	_, e = writer.RegisterVariables("example.logic", NewVariable("mosi", "wire", 8), NewVariable("cs", "wire", 1))
+	checkT(t, e)
+	_, e = writer.RegisterVariables("example", NewVariable("analogue", "real", 1), NewVariable("command", "string", 1))
+	checkT(t, e)
+	checkT(t, writer.SetValue(0, "1", "cs"))
+	writer.Close()
+
+	reader, e := NewReader(testDirectory + "layout.vcd")
+	checkT(t, e)
+	defer reader.Close()
+	reader.ParseHeader()
+
+	for name, variables := range map[string][]VcdDataType{"writer": writer.Variables(), "reader": reader.Variables()} {
+		GenerateGtkw(testDirectory+name, "layout.vcd", variables)
+		save, e := ParseGtkwFile(testDirectory + name + ".gtkw")
+		checkT(t, e)
+		traces := make(map[string]GtkwParsedTrace)
+		for _, trace := range save.Traces() {
+			traces[trace.Name] = trace
+		}
+		if len(traces) != 4 || save.Dumpfile() != "layout.vcd" {
+			t.Fatalf("%s: unexpected layout:\n%s", name, save)
+		}
+		mosi := traces["example.logic.mosi[7:0]"]
+		if mosi.Alias != "mosi" || !mosi.Flags.Has("hex") || strings.Join(mosi.Groups, "/") != "example/logic" {
+			t.Fatalf("%s: unexpected mosi trace %+v", name, mosi)
+		}
+		if !traces["example.logic.cs"].Flags.Has("bin") || !traces["example.analogue"].Flags.Has("analog_interpolated") {
+			t.Fatalf("%s: unexpected flags:\n%s", name, save)
+		}
+		if groups := traces["example.command"].Groups; len(groups) != 1 || groups[0] != "example" {
+			t.Fatalf("%s: unexpected command group:\n%s", name, save)
+		}
+		if !strings.Contains(save.String(), "[treeopen] example.\n[treeopen] example.logic.\n") {
+			t.Fatalf("%s: expected every scope to be open:\n%s", name, save)
+		}
+	}
+}
+
+func TestValidateGtkw(t *testing.T) {
+	variables := []VcdDataType{
+		{VariableName: "example.logic.mosi", VariableType: "wire", BitDepth: 8},
+		{VariableName: "example.logic.cs", VariableType: "wire", BitDepth: 1},
+		{VariableName: "example.analogue", VariableType: "real", BitDepth: 1},
+	}
+	gtkw := NewGtkw(testDirectory + "validate")
+	gtkw.Group("SPI", false,
+		Trace("example.logic.mosi[7:0]", "mosi", "hex"),
+		Trace("example.logic.mosi", "mosi", "hex"),
+		Trace("example.logic.mossi[7:0]", "mosi", "hex"),
+		Trace("example.logic.cs[0:0]", "cs"),
+		Trace("example.logic.cs", "cs", "analog_interpolated"),
+	)
+	gtkw.Trace(Trace("example.analogue", "analogue", "analog_interpolated"))
+	gtkw.Trace(Trace("example.logic.mosi[a:0]", "mosi", "hex"), Trace("example.logic.mosi[7:b]", "mosi", "hex"))
+	gtkw.writeFlags("grp_begin", "blank")
+	gtkw.write("-unbalanced\n")
+	issues := gtkw.Validate(variables)
+	gtkw.Close()
+
+	expected := []string{
+		"line 5: example.logic.mosi: missing bit range, expected example.logic.mosi[7:0]",
+		"line 6: example.logic.mossi[7:0]: unknown signal, did you mean example.logic.mosi[7:0]?",
+		"line 8: example.logic.cs[0:0]: signal has no bit range, expected example.logic.cs",
+		"line 10: example.logic.cs: analog display of a signal which is not real",
+		"line 16: example.logic.mosi[a:0]: invalid bit range",
+		"line 17: example.logic.mosi[7:b]: invalid bit range",
+		"line 19: group unbalanced is never ended",
+	}
+	if len(issues) != len(expected) {
+		t.Fatalf("unexpected issues %v", issues)
+	}
+	for i, issue := range issues {
+		if issue.Error() != expected[i] {
+			t.Fatalf("expected %s got %s", expected[i], issue.Error())
+		}
+	}
+}
+
+func TestTypedFlags(t *testing.T) {
+	if _, e := NewTrace("top.data[7:0]", "data", FlagHex|FlagDec); e == nil {
+		t.Fatal("expected an error for conflicting display formats")
+	}
+	if _, e := ParseFlags("hexx"); e == nil {
+		t.Fatal("expected an error for an unknown flag")
+	}
+	misspelled := Trace("top.data[7:0]", "data", "hexx", "rjustify")
+	if misspelled.flags != FlagRjustify {
+		t.Fatalf("expected the known flags to be kept, got %s", misspelled.flags)
+	}
+	var discarded strings.Builder
+	writer := NewGtkwWriter(&discarded)
+	if e := writer.Trace(misspelled.WithColor(ColorGreen)); e == nil || !strings.Contains(e.Error(), `"hexx"`) {
+		t.Fatalf("expected an error for the unknown flag, got %v", e)
+	}
+	if e := (FlagAnalogStep | FlagAnalogInterpolated).Validate(); e != nil {
+		t.Fatalf("expected interpolated annotated to be valid: %v", e)
+	}
+	if (FlagHex | FlagRjustify).String() != "hex|rjustify" {
+		t.Fatalf("unexpected flag names %s", FlagHex|FlagRjustify)
+	}
+	data, e := NewTrace("top.data[7:0]", "data", FlagHex|FlagRjustify)
+	checkT(t, e)
+	analogue, e := NewTrace("top.analogue", "analogue", FlagAnalogInterpolated|FlagReal)
+	checkT(t, e)
+	gtkw := NewGtkw(testDirectory + "flags")
+	gtkw.Trace(data.WithColor(ColorGreen), analogue.WithAnalogHeight(3).WithBlankLines(1), data)
+	gtkw.Close()
+
+	save, e := ParseGtkwFile(testDirectory + "flags.gtkw")
+	checkT(t, e)
+	expected := "@22\n[color] 4\n+{data} top.data[7:0]\n@50000\n+{analogue} top.analogue\n@20200\n-\n-\n@200\n-\n@22\n+{data} top.data[7:0]\n"
+	if save.String() != expected {
+		t.Fatalf("unexpected save file:\n%s", save)
+	}
+	traces := save.Traces()
+	if traces[0].Color != ColorGreen || traces[2].Color != ColorNormal || !traces[1].Flags.Has("real") {
+		t.Fatalf("unexpected traces %+v", traces)
+	}
+}
+
+func TestGtkwTree(t *testing.T) {
+	vector, e := Vector("nibble", FlagHex, Bits("top.a", 3, 2)...)
+	checkT(t, e)
+	gtkw := NewGtkw(testDirectory + "tree")
+	gtkw.Group("top", false,
+		Comment("--- bus ---"),
+		Group("inner", true, Trace("top.inner.clk", "clk", "bin")),
+		Blank(),
+		vector,
+	)
+	gtkw.Close()
+
+	save, e := ParseGtkwFile(testDirectory + "tree.gtkw")
+	checkT(t, e)
+	expected := "@800200\n-top\n@200\n---- bus ---\n@c00200\n-inner\n@8\n+{clk} top.inner.clk\n@1401200\n-inner\n@200\n-\n@2\n#{nibble} top.a[3] top.a[2]\n@1000200\n-top\n"
+	if save.String() != expected {
+		t.Fatalf("unexpected save file:\n%s", save)
+	}
+	if groups := save.Groups(); len(groups) != 2 || groups[1] != "top/inner" {
+		t.Fatalf("unexpected groups %v", groups)
+	}
+	traces := save.Traces()
+	if len(traces) != 2 || len(traces[0].Groups) != 2 || len(traces[1].Bits) != 2 {
+		t.Fatalf("unexpected traces %+v", traces)
+	}
+	if issues := save.Validate([]VcdDataType{{VariableName: "top.inner.clk", BitDepth: 1}, {VariableName: "top.a", BitDepth: 4}}); len(issues) != 0 {
+		t.Fatalf("unexpected issues %v", issues)
+	}
+}
+
+func TestEnumFilter(t *testing.T) {
+	if _, e := NewEnum(2, EnumValue{Value: 4, Label: "BAD"}); e == nil {
+		t.Fatal("expected an error for a value which does not fit")
+	}
+	enum, e := NewEnum(6,
+		EnumValue{Value: 2, Label: "WRITE", Color: "red"},
+		EnumValue{Value: 0, Label: "IDLE"},
+		EnumValue{Value: 1, Label: "READ"},
+	)
+	checkT(t, e)
+	state := VcdDataType{VariableName: "top.fsm.state", VariableType: "wire", BitDepth: 6}
+	trace, e := EnumTrace(state, testDirectory+"state.txt", enum)
+	checkT(t, e)
+	gtkw := NewGtkw(testDirectory + "enum")
+	gtkw.Trace(trace)
+	gtkw.Close()
+
+	filter, e := os.ReadFile(testDirectory + "state.txt")
+	checkT(t, e)
+	if string(filter) != "00 IDLE\n01 READ\n02 ?red?WRITE\n" {
+		t.Fatalf("unexpected filter file:\n%s", filter)
+	}
+	save, e := ParseGtkwFile(testDirectory + "enum.gtkw")
+	checkT(t, e)
+	expected := "@2002\n^1 " + testDirectory + "state.txt\n+{state} top.fsm.state[5:0]\n"
+	if save.String() != expected {
+		t.Fatalf("unexpected save file:\n%s", save)
+	}
+	if traces := save.Traces(); traces[0].Filter == nil || traces[0].Filter.Path != testDirectory+"state.txt" {
+		t.Fatalf("unexpected traces %+v", traces)
+	}
+}
+
+func TestProcessFilter(t *testing.T) {
+	trace := Trace("top.opcode[7:0]", "opcode", "hex").WithFilter("opcode.txt").WithProcess("/usr/local/bin/opcodes")
+	gtkw := NewGtkw(testDirectory + "process")
+	gtkw.Trace(trace)
+	gtkw.Close()
+
+	save, e := ParseGtkwFile(testDirectory + "process.gtkw")
+	checkT(t, e)
+	if save.String() != "@4002\n^>1 /usr/local/bin/opcodes\n+{opcode} top.opcode[7:0]\n" {
+		t.Fatalf("unexpected save file:\n%s", save)
+	}
+	if traces := save.Traces(); traces[0].Filter == nil || !traces[0].Filter.Process {
+		t.Fatalf("unexpected traces %+v", traces)
+	}
+}
+
+func TestSurfer(t *testing.T) {
+	gtkw := NewGtkw(testDirectory + "surfer")
+	gtkw.SetDumpfile("surfer.vcd")
+	checkT(t, gtkw.SetZoom(-4, 10, GtkwMarker{Time: 20, Name: "start"}))
+	gtkw.Group("top", false, Trace("top.data[7:0]", "data", "hex").WithColor(ColorRed), Comment("control"), Trace("top.clk", "clk"))
+	var out strings.Builder
+	checkT(t, gtkw.WriteSurfer(&out))
+	gtkw.Close()
+
+	expected := "load_file surfer.vcd\ndivider_add top\nvariable_add top.data\ndivider_add control\nvariable_add top.clk\n" +
+		"item_focus b\nitem_set_format Hexadecimal\nitem_set_color Red\nitem_unfocus\ncursor_set 10\nmarker_set start 20\n"
+	if out.String() != expected {
+		t.Fatalf("unexpected command file:\n%s", out.String())
+	}
+	if surferItemId(27, 30) != "bb" || surferItemId(3, 26) != "d" {
+		t.Fatalf("unexpected item ids %s %s", surferItemId(27, 30), surferItemId(3, 26))
+	}
+}
+
+func TestGtkwWriter(t *testing.T) {
+	var out strings.Builder
+	gtkw := NewGtkwWriter(&out)
+	checkT(t, gtkw.Trace(Trace("top.clk", "clk", "bin")))
+	checkT(t, gtkw.SetSignalsWidth(150))
+	checkT(t, gtkw.TreeOpen("top"))
+	checkT(t, gtkw.SetZoom(-2, 5))
+	checkT(t, gtkw.SetDumpfile("/tmp/top.vcd"))
+	checkT(t, gtkw.SetSignalsWidth(160))
+	if gtkw.Trace(Trace("top.data[7:0]", "data", "hex", "dec")) == nil {
+		t.Fatal("expected an error for conflicting flags")
+	}
+	if gtkw.Group("top", false, Comment("two\nlines")) == nil {
+		t.Fatal("expected an error for a comment spanning lines")
+	}
+	if gtkw.SetSize(0, 600) == nil {
+		t.Fatal("expected an error for an empty window")
+	}
+	if out.Len() != 0 {
+		t.Fatal("layout written before Close")
+	}
+	checkT(t, gtkw.Close())
+	expected := "[dumpfile] \"top.vcd\"\n*-2.000000 5" + strings.Repeat(" -1", 26) + "\n[treeopen] top.\n[signals_width] 160\n@8\n+{clk} top.clk\n"
+	if out.String() != expected {
+		t.Fatalf("unexpected save file:\n%s", out.String())
+	}
+	if gtkw.Trace(Trace("top.clk", "clk")) == nil || gtkw.Close() == nil {
+		t.Fatal("expected errors after Close")
+	}
+}
+
+func TestTimescaleHeader(t *testing.T) {
+	headers := map[string]string{
+		"separated": "$timescale 1 ns $end\n",
+		"icarus":    "$timescale\n\t1ns\n$end\n",
+		"spaces":    "$timescale\n 10 ps\n$end\n",
+	}
+	expected := map[string]string{"separated": "1ns", "icarus": "1ns", "spaces": "10ps"}
+	for name, header := range headers {
+		filename := testDirectory + name + ".vcd"
+		contents := header + "$scope module top $end\n$var wire 1 ! clk $end\n$upscope $end\n$enddefinitions $end\n#0\n0!\n"
+		checkT(t, os.WriteFile(filename, []byte(contents), 0644))
+		reader, e := NewReader(filename)
+		checkT(t, e)
+		reader.ParseHeader()
+		reader.Close()
+		if reader.Metadata().Timescale != expected[name] {
+			t.Fatalf("%s: unexpected timescale %q", name, reader.Metadata().Timescale)
+		}
+		if _, e = TimescaleSeconds(reader.Metadata().Timescale); e != nil {
+			t.Fatalf("%s: %v", name, e)
+		}
+	}
+	if seconds, e := TimescaleSeconds("\t100 us\n"); e != nil || seconds != 1e-4 {
+		t.Fatalf("unexpected seconds %g %v", seconds, e)
+	}
+}
+
+func TestUnknownValues(t *testing.T) {
+	filename := testDirectory + "unknown.vcd"
+	contents := "$timescale 1ns $end\n$scope module top $end\n$var wire 1 ! clk $end\n$var wire 4 \" data $end\n" +
+		"$upscope $end\n$enddefinitions $end\n#0\n0!\nb1010 \"\n" +
+		"$comment\n1!\nb1111 \"\n$end\n" +
+		"#10\n1?\nb11 ?\npD 6 0 <?\n$comment single line $end\n1!\n"
+	checkT(t, os.WriteFile(filename, []byte(contents), 0644))
+	reader, e := NewReader(filename)
+	checkT(t, e)
+	defer reader.Close()
+	values := reader.ReadAll()
+	if len(values) != 2 {
+		t.Fatalf("unexpected variables %v", values)
+	}
+	if clk := values["top.clk"]; len(clk) != 2 || clk[1].Time != 10 || clk[1].Value != "1" {
+		t.Fatalf("unexpected clk values %+v", clk)
+	}
+	if data := values["top.data"]; len(data) != 1 || data[0].Value != "1010" {
+		t.Fatalf("unexpected data values %+v", data)
+	}
+}
+
+func TestUnknownVectorBits(t *testing.T) {
+	writer, e := New(testDirectory+"vectorbits.vcd", "1ns")
+	checkT(t, e)
+	_, e = writer.RegisterVariables("top", NewVariable("data", "wire", 4))
+	checkT(t, e)
+	checkT(t, writer.SetValue(0, "b10x1", "data"))
+	checkT(t, writer.SetValue(10, "bz", "data"))
+	checkT(t, writer.SetValue(20, "x", "data"))
+	checkT(t, writer.SetValue(30, "b0x1z", "data"))
+	writer.Close()
+
+	reader, e := NewReader(testDirectory + "vectorbits.vcd")
+	checkT(t, e)
+	defer reader.Close()
+	data := reader.ReadAll()["top.data"]
+	expected := []string{"10x1", "z", "x", "0x1z"}
+	if len(data) != len(expected) {
+		t.Fatalf("unexpected data values %+v", data)
+	}
+	for i := range expected {
+		if data[i].Value != expected[i] {
+			t.Fatalf("expected %s got %v", expected[i], data[i].Value)
+		}
+	}
+
+	vector := VcdVectorType{bitDepth: 4, maxVal: 16}
+	if _, e = vector.format("b10x10"); e == nil {
+		t.Fatal("expected an error for a value wider than the bit depth")
+	}
+	if _, e = vector.format("b1021"); e == nil {
+		t.Fatal("expected an error for an invalid binary digit")
+	}
+}
@@ -0,0 +1,174 @@
+package vcd
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Writes value changes of registered variables, independent of the file format
+// Implemented by VcdWriter and FstWriter
+type WaveWriter interface {
+	RegisterVariables(module string, variables ...VcdDataType) (map[string]VcdDataType, error)
+	SetValue(time uint64, value string, variableName string) error
+	SetTimestamp(time uint64)
+	SetMetadata(version string, comment string)
+	Close()
+}
+
+// Reads the header and value changes of a waveform, independent of the file format
+// Implemented by VcdReader and FstReader
+type WaveReader interface {
+	ParseHeader()
+	GetIdentifiers() map[string]VcdDataType
+	Next() (bool, int64, string, interface{})
+	ReadAll() map[string][]ReadValue
+	Metadata() WaveMetadata
+	Close()
+}
+
+// Header fields shared by all waveform formats
+type WaveMetadata struct {
+	Date      string
+	Timescale string
+	Version   string
+	Comment   string
+}
+
+// Returns the duration of one time unit of a timescale such as 10ns in seconds
+func TimescaleSeconds(timeScale string) (float64, error) {
+	exponent, err := timescaleExponent(strings.Join(strings.Fields(timeScale), ""))
+	if err != nil {
+		return 0, err
+	}
+	return math.Pow10(int(exponent)), nil
+}
+
+// Describes a waveform format for the registry
+// Magic reports whether the first bytes of a file belong to this format
+type WaveFormat struct {
+	Name       string
+	Extensions []string
+	Magic      func(header []byte) bool
+	NewWriter  func(filename string, timeScale string) (WaveWriter, error)
+	NewReader  func(filename string) (WaveReader, error)
+}
+
+// Number of bytes passed to WaveFormat.Magic
+const magicLength = 16
+
+var waveFormats []WaveFormat
+
+func init() {
+	RegisterFormat(WaveFormat{
+		Name:       "vcd",
+		Extensions: []string{".vcd"},
+		Magic: func(header []byte) bool {
+			return bytes.HasPrefix(bytes.TrimLeft(header, " \t\r\n"), []byte("$"))
+		},
+		NewWriter: func(filename string, timeScale string) (WaveWriter, error) {
+			writer, err := New(filename, timeScale)
+			return &writer, err
+		},
+		NewReader: func(filename string) (WaveReader, error) {
+			reader, err := NewReader(filename)
+			return &reader, err
+		},
+	})
+	RegisterFormat(WaveFormat{
+		Name:       "fst",
+		Extensions: []string{".fst"},
+		Magic: func(header []byte) bool {
+			if len(header) < 9 {
+				return false
+			}
+			return (header[0] == fstBlockHeader && binary.BigEndian.Uint64(header[1:]) == fstHeaderLength) ||
+				header[0] == fstBlockZWrapper
+		},
+		NewWriter: func(filename string, timeScale string) (WaveWriter, error) {
+			writer, err := NewFst(filename, timeScale)
+			return &writer, err
+		},
+		NewReader: func(filename string) (WaveReader, error) {
+			reader, err := NewFstReader(filename)
+			return &reader, err
+		},
+	})
+}
+
+// Adds a format to the registry, replacing a registered format with the same name
+func RegisterFormat(format WaveFormat) {
+	for i, registered := range waveFormats {
+		if registered.Name == format.Name {
+			waveFormats[i] = format
+			return
+		}
+	}
+	waveFormats = append(waveFormats, format)
+}
+
+// Returns the registered formats
+func Formats() []WaveFormat {
+	return append([]WaveFormat(nil), waveFormats...)
+}
+
+// Returns the registered format with the given name
+func FormatByName(name string) (WaveFormat, error) {
+	for _, format := range waveFormats {
+		if format.Name == name {
+			return format, nil
+		}
+	}
+	return WaveFormat{}, fmt.Errorf("unknown waveform format: \"%s\"", name)
+}
+
+func formatByExtension(filename string) (WaveFormat, error) {
+	extension := strings.ToLower(filepath.Ext(filename))
+	for _, format := range waveFormats {
+		if stringInSlice(extension, format.Extensions) {
+			return format, nil
+		}
+	}
+	return WaveFormat{}, fmt.Errorf("no waveform format for extension: \"%s\"", extension)
+}
+
+// Creates a writer for the format matching the extension of filename
+func CreateWave(filename string, timeScale string) (WaveWriter, error) {
+	format, err := formatByExtension(filename)
+	if err != nil {
+		return nil, err
+	}
+	return format.NewWriter(filename, timeScale)
+}
+
+// Opens a reader for the format recognized from the start of the file, or else from its extension
+func OpenWave(filename string) (WaveReader, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, magicLength)
+	n, _ := f.Read(header)
+	_ = f.Close()
+	for _, format := range waveFormats {
+		if format.Magic != nil && format.Magic(header[:n]) {
+			return format.NewReader(filename)
+		}
+	}
+	format, err := formatByExtension(filename)
+	if err != nil {
+		return nil, err
+	}
+	return format.NewReader(filename)
+}
+
+var (
+	_ WaveWriter = &VcdWriter{}
+	_ WaveWriter = &FstWriter{}
+	_ WaveReader = &VcdReader{}
+	_ WaveReader = &FstReader{}
+)
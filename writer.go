@@ -2,6 +2,7 @@ package vcd
 
 import (
 	"bufio"
+	"bytes"
 	"fmt"
 	"os"
 	"strconv"
@@ -26,10 +27,15 @@ var supportedTimescaleUnit = []string{"s", "ms", "us", "ns", "ps", "fs"}
 type VcdWriter struct {
 	loadedFile          *os.File
 	buffered            *bufio.Writer
+	pending             *bytes.Buffer // Output of the current time, see commit
 	variableDefiner     int
 	stringIdentifierMap map[string]VcdDataType
+	variables           []VcdDataType
 	previousTime        uint64
 	headerFinalized     bool
+	flushInterval       time.Duration
+	flushThreshold      int
+	lastFlush           time.Time
 }
 
 // Creates a new VCDWriter object
@@ -43,10 +49,12 @@ func New(filename string, timeScale string) (VcdWriter, error) {
 	writer := VcdWriter{
 		loadedFile:          f,
 		buffered:            bufio.NewWriter(f),
+		pending:             new(bytes.Buffer),
 		variableDefiner:     33,
 		stringIdentifierMap: make(map[string]VcdDataType),
 		previousTime:        0,
 		headerFinalized:     false,
+		lastFlush:           time.Now(),
 	}
 	if err == nil {
 		dat := time.Now().Format("01-02-2006 15:04:05")
@@ -83,6 +91,8 @@ func initVariable(variable *VcdDataType, identifier string) error {
 		variable.marshal = VcdVectorType{bitDepth: variable.BitDepth, maxVal: 2 << (variable.BitDepth - 1)}
 	case "string":
 		variable.marshal = &VcdStringType{}
+	case "port":
+		variable.marshal = VcdPortType{bitDepth: variable.BitDepth}
 	default:
 		return fmt.Errorf("not implemented datatype: \"%s\"", variable.VariableType)
 	}
@@ -106,17 +116,7 @@ func check2(data interface{}, e error) interface{} {
 // Variables is an array of VcdDatatTypes
 // See writer.go -> NewVariable
 func (vcd *VcdWriter) RegisterVariableList(module string, variables []VcdDataType) (map[string]VcdDataType, error) {
-	check2(vcd.buffered.WriteString("$scope module " + module + " $end\n"))
-	for _, variable := range variables {
-		check(initVariable(&variable, string(vcd.variableDefiner)))
-
-		vcd.variableDefiner = vcd.variableDefiner + 1
-		response := fmt.Sprintf("%s %d %s %s", variable.VariableType, variable.BitDepth, variable.identifier, variable.VariableName)
-		vcd.stringIdentifierMap[variable.VariableName] = variable
-		check2(vcd.buffered.WriteString("$var " + response + " $end\n"))
-	}
-	check2(vcd.buffered.WriteString("$upscope $end\n"))
-	return vcd.stringIdentifierMap, nil
+	return vcd.RegisterVariables(module, variables...)
 }
 
 // Register variables
@@ -125,26 +125,43 @@ func (vcd *VcdWriter) RegisterVariableList(module string, variables []VcdDataTyp
 func (vcd *VcdWriter) RegisterVariables(module string, variables ...VcdDataType) (map[string]VcdDataType, error) {
 	check2(vcd.buffered.WriteString("$scope module " + module + " $end\n"))
 	for _, variable := range variables {
-		check(initVariable(&variable, string(vcd.variableDefiner)))
+		identifier := string(rune(vcd.variableDefiner))
+		size := strconv.Itoa(variable.BitDepth)
+		if variable.VariableType == "port" {
+			// Extended VCD ports use < identifiers and a bit range as size
+			identifier = "<" + identifier
+			if variable.BitDepth > 1 {
+				size = fmt.Sprintf("[%d:0]", variable.BitDepth-1)
+			}
+		}
+		check(initVariable(&variable, identifier))
 
 		vcd.variableDefiner = vcd.variableDefiner + 1
-		response := fmt.Sprintf("%s %d %s %s", variable.VariableType, variable.BitDepth, variable.identifier, variable.VariableName)
+		response := fmt.Sprintf("%s %s %s %s", variable.VariableType, size, variable.identifier, variable.VariableName)
 		vcd.stringIdentifierMap[variable.VariableName] = variable
+		registered := variable
+		registered.VariableName = module + "." + variable.VariableName
+		vcd.variables = append(vcd.variables, registered)
 		check2(vcd.buffered.WriteString("$var " + response + " $end\n"))
 	}
 	check2(vcd.buffered.WriteString("$upscope $end\n"))
 	return vcd.stringIdentifierMap, nil
 }
 
+// Returns the registered variables in order of registration, named by their full path such as example.logic.mosi
+func (vcd *VcdWriter) Variables() []VcdDataType {
+	return append([]VcdDataType(nil), vcd.variables...)
+}
+
 func (vcd *VcdWriter) DumpValues(identifierToValue map[string]string) {
-	_, e := vcd.buffered.WriteString("$dumpvars\n")
+	_, e := vcd.pending.WriteString("$dumpvars\n")
 	check(e)
 	for i, _ := range identifierToValue {
 		val, e := vcd.stringIdentifierMap[i].marshal.format(identifierToValue[i])
 		check(e)
-		check2(vcd.buffered.WriteString(val + " " + vcd.stringIdentifierMap[i].identifier + "\n"))
+		check2(vcd.pending.WriteString(val + " " + vcd.stringIdentifierMap[i].identifier + "\n"))
 	}
-	_, e = vcd.buffered.WriteString("$end\n")
+	_, e = vcd.pending.WriteString("$end\n")
 	check(e)
 }
 
@@ -156,12 +173,14 @@ func (vcd *VcdWriter) SetValue(time uint64, value string, variableName string) e
 		return fmt.Errorf("changing value from an earlier time: %d < %d", time, vcd.previousTime)
 	}
 	if time != vcd.previousTime {
-		_, _ = vcd.buffered.WriteString("#" + strconv.FormatUint(time, 10) + "\n")
+		vcd.commit()
+		vcd.flushIfDue()
+		_, _ = vcd.pending.WriteString("#" + strconv.FormatUint(time, 10) + "\n")
 		vcd.previousTime = time
 	}
 
 	if !vcd.headerFinalized {
-		check2(vcd.buffered.WriteString("$enddefinitions $end\n"))
+		check2(vcd.pending.WriteString("$enddefinitions $end\n"))
 		vcd.headerFinalized = true
 	}
 
@@ -173,7 +192,7 @@ func (vcd *VcdWriter) SetValue(time uint64, value string, variableName string) e
 			panic(e)
 		}
 	}
-	check2(vcd.buffered.WriteString(format + " " + vcd.stringIdentifierMap[variableName].identifier + "\n"))
+	check2(vcd.pending.WriteString(format + " " + vcd.stringIdentifierMap[variableName].identifier + "\n"))
 	return e
 }
 
@@ -191,12 +210,74 @@ func (vcd *VcdWriter) SetVersion(version string) *VcdWriter {
 	return vcd
 }
 
+// Sets the Version and Comment in the vcd, skipping empty ones
+// Can only be used before registering the variables
+func (vcd *VcdWriter) SetMetadata(version string, comment string) {
+	if version != "" {
+		vcd.SetVersion(version)
+	}
+	if comment != "" {
+		vcd.SetComment(comment)
+	}
+}
+
 func (vcd *VcdWriter) SetTimestamp(time uint64) {
-	_, _ = vcd.buffered.WriteString("#" + strconv.FormatUint(time, 10) + "\n")
+	vcd.commit()
+	vcd.flushIfDue()
+	_, _ = vcd.pending.WriteString("#" + strconv.FormatUint(time, 10) + "\n")
+}
+
+// Flushes the buffered output to the file at most every interval
+// Flushing only happens when the time advances, so the file always ends with complete timestamps
+// An interval of 0 disables periodic flushing
+func (vcd *VcdWriter) SetFlushInterval(interval time.Duration) *VcdWriter {
+	vcd.flushInterval = interval
+	return vcd
+}
+
+// Flushes the buffered output to the file once at least threshold bytes are pending
+// Flushing only happens when the time advances, so the file always ends with complete timestamps
+// A threshold of 0 disables threshold flushing
+func (vcd *VcdWriter) SetFlushThreshold(threshold int) *VcdWriter {
+	if threshold > vcd.buffered.Size() {
+		// Grow the buffer, otherwise bufio flushes before the threshold is reached
+		check(vcd.buffered.Flush())
+		vcd.buffered = bufio.NewWriterSize(vcd.loadedFile, threshold)
+	}
+	vcd.flushThreshold = threshold
+	return vcd
+}
+
+// Writes all buffered output to the file, including the value changes of the current time
+func (vcd *VcdWriter) Flush() error {
+	vcd.commit()
+	vcd.lastFlush = time.Now()
+	return vcd.buffered.Flush()
+}
+
+// Hands the output of the current time to the buffered writer in one piece
+// The buffered writer is flushed first when the output does not fit, so it never writes part of a time
+func (vcd *VcdWriter) commit() {
+	if vcd.pending.Len() > vcd.buffered.Available() {
+		check(vcd.buffered.Flush())
+	}
+	check2(vcd.buffered.Write(vcd.pending.Bytes()))
+	vcd.pending.Reset()
+}
+
+func (vcd *VcdWriter) flushIfDue() {
+	due := vcd.flushThreshold > 0 && vcd.buffered.Buffered() >= vcd.flushThreshold
+	if vcd.flushInterval > 0 && time.Since(vcd.lastFlush) >= vcd.flushInterval {
+		due = true
+	}
+	if due {
+		check(vcd.Flush())
+	}
 }
 
 // Closes and flushes the files
 func (vcd VcdWriter) Close() {
+	vcd.commit()
 	check(vcd.buffered.Flush())
 	check(vcd.loadedFile.Close())
 }